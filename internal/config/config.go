@@ -39,6 +39,15 @@ func ReadConfig(ConfigFile string) {
 	viper.SetDefault("share.did", "")
 	viper.SetDefault("app.env", Environment)
 	viper.SetDefault("ip.prefer", "")
+	viper.SetDefault("throughput.enable", "no")
+	viper.SetDefault("throughput.port", "5201")
+	viper.SetDefault("throughput.key", "")
+	viper.SetDefault("twamp.enable", "no")
+	viper.SetDefault("twamp.port", "862")
+	viper.SetDefault("dns.trustanchor", "")
+	viper.SetDefault("dns.server", "")
+	viper.SetDefault("dns.transport", "udp")
+	viper.SetDefault("sweep.maxprefix", "22")
 
     if (ConfigFile != "") {
 		viper.SetConfigFile(ConfigFile)
@@ -8,6 +8,8 @@ import (
 	"sync"
 
 	"github.com/admuu/adm-agent/internal/config"
+	"github.com/admuu/adm-agent/pkg/components"
+	"github.com/admuu/adm-agent/pkg/network"
 	"github.com/admuu/adm-agent/pkg/utils"
 	"github.com/spf13/viper"
 )
@@ -51,6 +53,11 @@ func (ps *Processor) Process() error {
     }()
 
 	ps.preRun()
+	ps.detectICMPMode()
+	ps.startResponders()
+	if _, err := components.SweepMaxPrefix(); err != nil {
+		log.Errorf("Sweep tasks are refused: %v", err)
+	}
 
 	go func() {
 		defer func() {
@@ -98,6 +105,43 @@ func (ps *Processor) Process() error {
 	return err
 }
 
+func (ps *Processor) detectICMPMode() {
+	switch mode := network.DetectICMPMode(); mode {
+	case network.ICMPModePrivileged:
+		log.Info("ICMP mode: " + mode)
+	case network.ICMPModeUnprivileged:
+		log.Warn("ICMP mode: " + mode + ", raw sockets are not permitted, traceroute is unavailable")
+	default:
+		log.Warn("ICMP mode: " + mode + ", grant CAP_NET_RAW or add the service group to net.ipv4.ping_group_range")
+	}
+}
+
+// startResponders serves the measurements other agents run against this one, each is opt-in
+func (ps *Processor) startResponders() {
+	if viper.GetString("throughput.enable") == "yes" && viper.GetString("throughput.key") == "" {
+		log.Error("Throughput responder not started, set throughput.key to the key the testing agents send")
+	} else if viper.GetString("throughput.enable") == "yes" {
+		addr := ":" + viper.GetString("throughput.port")
+		responder := &components.ThroughputResponder{Key: viper.GetString("throughput.key")}
+		go func() {
+			if err := responder.ListenAndServe(addr); err != nil {
+				log.Errorf("Throughput responder failed: %v", err)
+			}
+		}()
+		log.Info("Throughput responder listening on " + addr)
+	}
+	if viper.GetString("twamp.enable") == "yes" {
+		addr := ":" + viper.GetString("twamp.port")
+		reflector := &components.TWAMPReflector{}
+		go func() {
+			if err := reflector.ListenAndServe(addr); err != nil {
+				log.Errorf("TWAMP reflector failed: %v", err)
+			}
+		}()
+		log.Info("TWAMP reflector listening on " + addr)
+	}
+}
+
 func (ps *Processor) Register() {
 	var isProcess bool
     defer func() {
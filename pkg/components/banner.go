@@ -0,0 +1,650 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+)
+
+const (
+	bannerDefaultTimeout = 5000
+	bannerMaxLines       = 64
+	bannerClientName     = "adm-agent"
+)
+
+// bannerProtocol describes how to greet one application protocol
+type bannerProtocol struct {
+	port     string
+	starttls bool
+	probe    func(s *bannerSession, starttls bool) error
+}
+
+// bannerProtocols lists the supported protocols with their default port
+var bannerProtocols = map[string]bannerProtocol{
+	"smtp":       {port: "25", starttls: true, probe: probeSMTP},
+	"ftp":        {port: "21", starttls: true, probe: probeFTP},
+	"ssh":        {port: "22", probe: probeSSH},
+	"redis":      {port: "6379", probe: probeRedis},
+	"mysql":      {port: "3306", starttls: true, probe: probeMySQL},
+	"postgresql": {port: "5432", starttls: true, probe: probePostgreSQL},
+}
+
+// BannerHandler handles application protocol handshake tasks
+type BannerHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (bh *BannerHandler) ValidateData(data map[string]interface{}) error {
+	if data["host"] == nil || data["protocol"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	protocol, ok := bannerProtocols[bannerProtocolName(data)]
+	if !ok {
+		return fmt.Errorf("unsupported protocol: %v", data["protocol"])
+	}
+	if getBoolParam(data, "starttls") {
+		if !protocol.starttls {
+			return fmt.Errorf("%v does not support STARTTLS", data["protocol"])
+		}
+		if getBoolParam(data, "tls") {
+			return fmt.Errorf("tls and starttls are mutually exclusive")
+		}
+	}
+	if err := checkIntParam(data, "timeout", 500, 30000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v Banner %v %v\n", clientIP, data["protocol"], data["host"])
+	}
+	return nil
+}
+
+// PreProcess resolves the target and picks the default port of the protocol
+func (bh *BannerHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	// Clean host string and remove brackets for IPv6
+	host := strings.Trim(data["host"].(string), " \n\"'")
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = strings.Trim(host, "[]")
+	}
+
+	ip, hostname, port, ipVersion, err := network.FilterIP(host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterIP error: %v", err)
+	}
+
+	// FilterIP falls back to port 80, use the protocol default unless given
+	protocol := bannerProtocolName(data)
+	if _, _, splitErr := net.SplitHostPort(host); splitErr != nil {
+		port = bannerProtocols[protocol].port
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["ip"] = ip
+	processedData["host"] = hostname
+	processedData["port"] = port
+	processedData["protocol"] = protocol
+	processedData["ipVersion"] = ipVersion
+
+	response := map[string]interface{}{
+		"ip":        ip,
+		"port":      port,
+		"ipVersion": ipVersion,
+		"taskType":  bh.GetTaskType(),
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute connects to the service, performs the handshake and reports the banner
+func (bh *BannerHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	ip := data["ip"].(string)
+	port := data["port"].(string)
+	timeout := time.Duration(getIntParam(data, "timeout", bannerDefaultTimeout)) * time.Millisecond
+	options := BannerOptions{
+		Protocol: data["protocol"].(string),
+		TLS:      getBoolParam(data, "tls"),
+		StartTLS: getBoolParam(data, "starttls"),
+		SNI:      getStringParam(data, "sni", ""),
+	}
+	if options.SNI == "" && net.ParseIP(data["host"].(string)) == nil {
+		options.SNI = data["host"].(string)
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, port), timeout)
+	var result *BannerResult
+	if err == nil {
+		defer conn.Close()
+		connectTime := time.Since(start)
+		if err = conn.SetDeadline(start.Add(timeout)); err == nil {
+			result, err = RunBannerProbe(conn, options)
+		}
+		if result != nil {
+			result.Phases = append([]BannerPhase{{Name: "connect", Time: roundToDecimal(float64(connectTime.Microseconds())/1000.0, 3)}}, result.Phases...)
+		}
+	} else {
+		err = fmt.Errorf("connect error: %v", err)
+	}
+
+	res := map[string]interface{}{
+		"protocol":  options.Protocol,
+		"totalTime": roundToDecimal(float64(time.Since(start).Microseconds())/1000.0, 3),
+		"taskType":  bh.GetTaskType(),
+		"taskId":    taskId,
+	}
+	if result != nil {
+		res["banner"] = result.Banner
+		res["phases"] = result.Phases
+		res["details"] = result.Details
+		if result.TLSVersion != "" {
+			res["tlsVersion"] = result.TLSVersion
+			res["cipherSuite"] = result.CipherSuite
+		}
+	}
+	if err != nil {
+		res["error"] = err.Error()
+	}
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// GetTaskType returns the task type identifier
+func (bh *BannerHandler) GetTaskType() string {
+	return "banner"
+}
+
+// bannerProtocolName returns the normalized protocol name of a task
+func bannerProtocolName(data map[string]interface{}) string {
+	protocol := strings.ToLower(getStringParam(data, "protocol", ""))
+	if protocol == "postgres" {
+		protocol = "postgresql"
+	}
+	return protocol
+}
+
+// BannerOptions selects the protocol and TLS mode of a banner probe
+type BannerOptions struct {
+	Protocol string
+	TLS      bool
+	StartTLS bool
+	SNI      string
+}
+
+// BannerPhase is the duration of one step of the handshake in milliseconds
+type BannerPhase struct {
+	Name string  `json:"name"`
+	Time float64 `json:"time"`
+}
+
+// BannerResult is the outcome of a banner probe
+type BannerResult struct {
+	Banner      string
+	Phases      []BannerPhase
+	Details     map[string]interface{}
+	TLSVersion  string
+	CipherSuite string
+}
+
+// RunBannerProbe speaks the protocol over an established connection and collects the banner.
+// A partial result is returned with the error when the handshake fails midway.
+func RunBannerProbe(conn net.Conn, options BannerOptions) (*BannerResult, error) {
+	protocol, ok := bannerProtocols[options.Protocol]
+	if !ok {
+		return nil, fmt.Errorf("unsupported protocol: %s", options.Protocol)
+	}
+
+	s := &bannerSession{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		last:   time.Now(),
+		sni:    options.SNI,
+		result: &BannerResult{Details: make(map[string]interface{})},
+	}
+	if options.TLS {
+		if err := s.startTLS(); err != nil {
+			return s.result, err
+		}
+	}
+	err := protocol.probe(s, options.StartTLS)
+	return s.result, err
+}
+
+// bannerSession wraps a connection and records the duration of each handshake phase
+type bannerSession struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	last   time.Time
+	sni    string
+	result *BannerResult
+}
+
+// mark records the time since the previous phase under name
+func (s *bannerSession) mark(name string) {
+	now := time.Now()
+	s.result.Phases = append(s.result.Phases, BannerPhase{Name: name, Time: roundToDecimal(float64(now.Sub(s.last).Microseconds())/1000.0, 3)})
+	s.last = now
+}
+
+// startTLS upgrades the connection, certificates are inspected by the tls task rather than verified here
+func (s *bannerSession) startTLS() error {
+	tlsConn := tls.Client(s.conn, &tls.Config{ServerName: s.sni, InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("tls handshake error: %v", err)
+	}
+	s.conn = tlsConn
+	s.reader = bufio.NewReader(tlsConn)
+	state := tlsConn.ConnectionState()
+	s.result.TLSVersion = tls.VersionName(state.Version)
+	s.result.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	s.mark("tls")
+	return nil
+}
+
+// write sends raw bytes
+func (s *bannerSession) write(b []byte) error {
+	if _, err := s.conn.Write(b); err != nil {
+		return fmt.Errorf("send error: %v", err)
+	}
+	return nil
+}
+
+// writeLine sends a CRLF terminated command
+func (s *bannerSession) writeLine(line string) error {
+	return s.write([]byte(line + "\r\n"))
+}
+
+// readLine reads one line without its line ending
+func (s *bannerSession) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		if line != "" && err == io.EOF {
+			return strings.TrimRight(line, "\r\n"), nil
+		}
+		return "", fmt.Errorf("receive error: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readReply reads an SMTP or FTP reply, following "123-" continuation lines
+func (s *bannerSession) readReply() (int, []string, error) {
+	line, err := s.readLine()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(line) < 3 {
+		return 0, nil, fmt.Errorf("malformed reply: %q", line)
+	}
+	code, err := strconv.Atoi(line[:3])
+	if err != nil {
+		return 0, nil, fmt.Errorf("malformed reply: %q", line)
+	}
+
+	lines := []string{strings.TrimSpace(line[3:])}
+	if len(line) > 3 && line[3] == '-' {
+		lines[0] = line[4:]
+		end := line[:3] + " "
+		for i := 0; i < bannerMaxLines; i++ {
+			next, err := s.readLine()
+			if err != nil {
+				return code, lines, err
+			}
+			if strings.HasPrefix(next, end) || next == line[:3] {
+				lines = append(lines, strings.TrimSpace(strings.TrimPrefix(next, line[:3])))
+				return code, lines, nil
+			}
+			lines = append(lines, strings.TrimPrefix(next, line[:3]+"-"))
+		}
+		return code, lines, fmt.Errorf("reply exceeds %d lines", bannerMaxLines)
+	}
+	return code, lines, nil
+}
+
+// expectReply reads a reply and fails unless its code matches
+func (s *bannerSession) expectReply(want int, phase string) ([]string, error) {
+	code, lines, err := s.readReply()
+	if err != nil {
+		return lines, err
+	}
+	s.mark(phase)
+	if code != want {
+		return lines, fmt.Errorf("unexpected %s reply: %d %s", phase, code, strings.Join(lines, " "))
+	}
+	return lines, nil
+}
+
+// probeSMTP reads the greeting, sends EHLO and optionally upgrades with STARTTLS
+func probeSMTP(s *bannerSession, starttls bool) error {
+	lines, err := s.expectReply(220, "banner")
+	if len(lines) > 0 {
+		s.result.Banner = lines[0]
+	}
+	if err != nil {
+		return err
+	}
+
+	ehlo := func(phase string) error {
+		if err := s.writeLine("EHLO " + bannerClientName); err != nil {
+			return err
+		}
+		lines, err := s.expectReply(250, phase)
+		if err != nil {
+			return err
+		}
+		if len(lines) > 1 {
+			s.result.Details["extensions"] = lines[1:]
+		}
+		return nil
+	}
+	if err := ehlo("ehlo"); err != nil {
+		return err
+	}
+
+	if starttls {
+		if err := s.writeLine("STARTTLS"); err != nil {
+			return err
+		}
+		if _, err := s.expectReply(220, "starttls"); err != nil {
+			return err
+		}
+		if err := s.startTLS(); err != nil {
+			return err
+		}
+		// Extensions may change once the session is encrypted
+		if err := ehlo("ehlo"); err != nil {
+			return err
+		}
+	}
+	return s.writeLine("QUIT")
+}
+
+// probeFTP reads the greeting and optionally upgrades with AUTH TLS
+func probeFTP(s *bannerSession, starttls bool) error {
+	lines, err := s.expectReply(220, "banner")
+	if len(lines) > 0 {
+		s.result.Banner = strings.Join(lines, "\n")
+	}
+	if err != nil {
+		return err
+	}
+
+	if starttls {
+		if err := s.writeLine("AUTH TLS"); err != nil {
+			return err
+		}
+		if _, err := s.expectReply(234, "auth"); err != nil {
+			return err
+		}
+		if err := s.startTLS(); err != nil {
+			return err
+		}
+	}
+	return s.writeLine("QUIT")
+}
+
+// probeSSH reads the identification string the server sends first
+func probeSSH(s *bannerSession, starttls bool) error {
+	// Servers may send other lines before the identification string
+	for i := 0; i < bannerMaxLines; i++ {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "SSH-") {
+			continue
+		}
+		s.mark("banner")
+		s.result.Banner = line
+
+		// SSH-protoversion-softwareversion SP comments
+		ident, comments, _ := strings.Cut(line, " ")
+		parts := strings.SplitN(ident, "-", 3)
+		if len(parts) == 3 {
+			s.result.Details["protoVersion"] = parts[1]
+			s.result.Details["software"] = parts[2]
+		}
+		if comments != "" {
+			s.result.Details["comments"] = comments
+		}
+		return nil
+	}
+	return fmt.Errorf("no SSH identification string received")
+}
+
+// probeRedis sends PING, an authentication error still proves the server is answering
+func probeRedis(s *bannerSession, starttls bool) error {
+	if err := s.writeLine("PING"); err != nil {
+		return err
+	}
+	line, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	s.mark("ping")
+	s.result.Banner = line
+
+	switch {
+	case strings.HasPrefix(line, "+"):
+		s.result.Details["authRequired"] = false
+	case strings.HasPrefix(line, "-NOAUTH"):
+		s.result.Details["authRequired"] = true
+	case strings.HasPrefix(line, "-"):
+		return fmt.Errorf("redis error: %s", strings.TrimPrefix(line, "-"))
+	default:
+		return fmt.Errorf("unexpected redis reply: %q", line)
+	}
+	return nil
+}
+
+// MySQL capability flags used by the probe
+const (
+	mysqlClientLongPassword   = 0x00000001
+	mysqlClientProtocol41     = 0x00000200
+	mysqlClientSSL            = 0x00000800
+	mysqlClientSecureConn     = 0x00008000
+	mysqlMaxPacketSize        = 1 << 24
+	mysqlCharsetUTF8          = 33
+	mysqlHandshakeProtocolV10 = 10
+)
+
+// readMySQLPacket reads one MySQL protocol packet and returns its payload
+func readMySQLPacket(s *bannerSession) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(s.reader, header); err != nil {
+		return nil, fmt.Errorf("receive error: %v", err)
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, payload); err != nil {
+		return nil, fmt.Errorf("receive error: %v", err)
+	}
+	return payload, nil
+}
+
+// probeMySQL parses the initial handshake packet and optionally sends an SSL request
+func probeMySQL(s *bannerSession, starttls bool) error {
+	payload, err := readMySQLPacket(s)
+	if err != nil {
+		return err
+	}
+	s.mark("banner")
+
+	// The server refuses some clients with an error packet instead of a handshake
+	if len(payload) > 0 && payload[0] == 0xff {
+		message := ""
+		if len(payload) > 3 {
+			message = string(payload[3:])
+			if strings.HasPrefix(message, "#") && len(message) > 6 {
+				message = message[6:]
+			}
+		}
+		s.result.Banner = message
+		return fmt.Errorf("mysql error: %s", message)
+	}
+	if len(payload) < 1 || payload[0] != mysqlHandshakeProtocolV10 {
+		return fmt.Errorf("unsupported mysql handshake")
+	}
+
+	versionEnd := bytes.IndexByte(payload[1:], 0)
+	if versionEnd < 0 || len(payload) < 1+versionEnd+1+4+8+1+2 {
+		return fmt.Errorf("malformed mysql handshake")
+	}
+	version := string(payload[1 : 1+versionEnd])
+	pos := 1 + versionEnd + 1
+	connectionID := binary.LittleEndian.Uint32(payload[pos : pos+4])
+	pos += 4 + 8 + 1
+	capabilities := uint32(binary.LittleEndian.Uint16(payload[pos : pos+2]))
+	pos += 2
+	if len(payload) >= pos+5 {
+		capabilities |= uint32(binary.LittleEndian.Uint16(payload[pos+3:pos+5])) << 16
+	}
+
+	s.result.Banner = version
+	s.result.Details["serverVersion"] = version
+	s.result.Details["connectionId"] = connectionID
+	s.result.Details["sslSupported"] = capabilities&mysqlClientSSL != 0
+
+	if !starttls {
+		return nil
+	}
+	if capabilities&mysqlClientSSL == 0 {
+		return fmt.Errorf("server does not support SSL")
+	}
+
+	// SSL request: capabilities, max packet size, charset and 23 reserved bytes
+	request := make([]byte, 4+32)
+	request[0] = 32
+	request[3] = 1
+	binary.LittleEndian.PutUint32(request[4:8], mysqlClientLongPassword|mysqlClientProtocol41|mysqlClientSSL|mysqlClientSecureConn)
+	binary.LittleEndian.PutUint32(request[8:12], mysqlMaxPacketSize)
+	request[12] = mysqlCharsetUTF8
+	if err := s.write(request); err != nil {
+		return err
+	}
+	return s.startTLS()
+}
+
+// PostgreSQL protocol constants used by the probe
+const (
+	postgresSSLRequestCode  = 80877103
+	postgresProtocolVersion = 196608
+)
+
+// postgresAuthMethods names the authentication request codes
+var postgresAuthMethods = map[uint32]string{
+	0:  "ok",
+	2:  "kerberos",
+	3:  "cleartext",
+	5:  "md5",
+	7:  "gss",
+	9:  "sspi",
+	10: "sasl",
+}
+
+// probePostgreSQL optionally negotiates SSL and sends a startup message to learn the authentication method
+func probePostgreSQL(s *bannerSession, starttls bool) error {
+	if starttls {
+		request := make([]byte, 8)
+		binary.BigEndian.PutUint32(request[0:4], 8)
+		binary.BigEndian.PutUint32(request[4:8], postgresSSLRequestCode)
+		if err := s.write(request); err != nil {
+			return err
+		}
+		answer, err := s.reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("receive error: %v", err)
+		}
+		s.mark("sslrequest")
+		s.result.Details["sslSupported"] = answer == 'S'
+		if answer != 'S' {
+			return fmt.Errorf("server refused SSL")
+		}
+		if err := s.startTLS(); err != nil {
+			return err
+		}
+	}
+
+	var params bytes.Buffer
+	for _, kv := range []string{"user", bannerClientName, "database", "postgres", "application_name", bannerClientName} {
+		params.WriteString(kv)
+		params.WriteByte(0)
+	}
+	params.WriteByte(0)
+	startup := make([]byte, 8, 8+params.Len())
+	binary.BigEndian.PutUint32(startup[0:4], uint32(8+params.Len()))
+	binary.BigEndian.PutUint32(startup[4:8], postgresProtocolVersion)
+	if err := s.write(append(startup, params.Bytes()...)); err != nil {
+		return err
+	}
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(s.reader, header); err != nil {
+		return fmt.Errorf("receive error: %v", err)
+	}
+	length := int(binary.BigEndian.Uint32(header[1:5])) - 4
+	if length < 0 || length > 1<<16 {
+		return fmt.Errorf("malformed postgresql message")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, body); err != nil {
+		return fmt.Errorf("receive error: %v", err)
+	}
+	s.mark("startup")
+
+	switch header[0] {
+	case 'R':
+		if len(body) < 4 {
+			return fmt.Errorf("malformed postgresql authentication request")
+		}
+		code := binary.BigEndian.Uint32(body[0:4])
+		method, ok := postgresAuthMethods[code]
+		if !ok {
+			method = strconv.Itoa(int(code))
+		}
+		s.result.Details["authMethod"] = method
+		s.result.Banner = "authentication request: " + method
+		if code == 10 {
+			var mechanisms []string
+			for _, m := range bytes.Split(bytes.TrimRight(body[4:], "\x00"), []byte{0}) {
+				mechanisms = append(mechanisms, string(m))
+			}
+			s.result.Details["saslMechanisms"] = mechanisms
+		}
+		return nil
+	case 'E':
+		// Error fields are a type byte followed by a null terminated string
+		fields := make(map[byte]string)
+		for _, field := range bytes.Split(body, []byte{0}) {
+			if len(field) > 1 {
+				fields[field[0]] = string(field[1:])
+			}
+		}
+		s.result.Banner = fields['M']
+		s.result.Details["sqlState"] = fields['C']
+		// Rejecting the probe user proves the server is processing startups
+		return nil
+	default:
+		return fmt.Errorf("unexpected postgresql message type %q", header[0])
+	}
+}
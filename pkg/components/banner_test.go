@@ -0,0 +1,533 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bannerTestServer is the server end of a probe connection, failures are reported on t
+type bannerTestServer struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	cert   tls.Certificate
+}
+
+// send writes raw bytes to the client
+func (s *bannerTestServer) send(data string) {
+	if _, err := s.conn.Write([]byte(data)); err != nil {
+		s.t.Errorf("server send: %v", err)
+	}
+}
+
+// expectLine reads one CRLF terminated line and checks it
+func (s *bannerTestServer) expectLine(want string) {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		s.t.Errorf("server read %q: %v", want, err)
+		return
+	}
+	if got := strings.TrimRight(line, "\r\n"); got != want {
+		s.t.Errorf("server read %q, want %q", got, want)
+	}
+}
+
+// read reads exactly n bytes
+func (s *bannerTestServer) read(n int) []byte {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(s.reader, buf); err != nil {
+		s.t.Errorf("server read %d bytes: %v", n, err)
+	}
+	return buf
+}
+
+// bufferedConn reads through a reader that may already hold the start of a TLS handshake
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// startTLS completes the server side of a TLS handshake
+func (s *bannerTestServer) startTLS() {
+	// A MySQL client sends its ClientHello right after the SSL request without waiting
+	conn := &bufferedConn{Conn: s.conn, reader: s.reader}
+	tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+	if err := tlsConn.Handshake(); err != nil {
+		s.t.Errorf("server tls handshake: %v", err)
+		return
+	}
+	s.conn = tlsConn
+	s.reader = bufio.NewReader(tlsConn)
+}
+
+// bannerTestCertificate creates a self signed certificate for the TLS paths
+func bannerTestCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// runBannerTest runs the probe against serve over a loopback connection
+func runBannerTest(t *testing.T, options BannerOptions, serve func(s *bannerTestServer)) (*BannerResult, error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cert := bannerTestCertificate(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		serve(&bannerTestServer{t: t, conn: conn, reader: bufio.NewReader(conn), cert: cert})
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	result, err := RunBannerProbe(conn, options)
+	conn.Close()
+	<-done
+	return result, err
+}
+
+// bannerPhaseNames lists the recorded phases in order
+func bannerPhaseNames(result *BannerResult) []string {
+	names := make([]string, 0, len(result.Phases))
+	for _, phase := range result.Phases {
+		names = append(names, phase.Name)
+	}
+	return names
+}
+
+// mysqlHandshake builds an initial handshake packet advertising capabilities
+func mysqlHandshake(version string, connectionID uint32, capabilities uint32) string {
+	payload := []byte{mysqlHandshakeProtocolV10}
+	payload = append(payload, version...)
+	payload = append(payload, 0)
+	payload = binary.LittleEndian.AppendUint32(payload, connectionID)
+	payload = append(payload, "12345678"...)
+	payload = append(payload, 0)
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(capabilities))
+	payload = append(payload, mysqlCharsetUTF8, 2, 0)
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(capabilities>>16))
+	return mysqlPacket(payload)
+}
+
+// mysqlPacket prefixes payload with the packet header
+func mysqlPacket(payload []byte) string {
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0}
+	return string(append(header, payload...))
+}
+
+// postgresMessage frames a backend message of type kind
+func postgresMessage(kind byte, body []byte) string {
+	message := []byte{kind}
+	message = binary.BigEndian.AppendUint32(message, uint32(4+len(body)))
+	return string(append(message, body...))
+}
+
+// expectPostgresStartup reads the startup message and checks its protocol version
+func expectPostgresStartup(s *bannerTestServer) {
+	length := binary.BigEndian.Uint32(s.read(4))
+	body := s.read(int(length) - 4)
+	if len(body) < 4 || binary.BigEndian.Uint32(body[0:4]) != postgresProtocolVersion {
+		s.t.Errorf("unexpected startup message %q", body)
+	}
+}
+
+func TestRunBannerProbe(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     BannerOptions
+		serve       func(s *bannerTestServer)
+		wantErr     string
+		wantBanner  string
+		wantPhases  []string
+		wantDetails map[string]interface{}
+		wantTLS     bool
+	}{
+		{
+			name:    "smtp",
+			options: BannerOptions{Protocol: "smtp"},
+			serve: func(s *bannerTestServer) {
+				s.send("220 mail.example.com ESMTP ready\r\n")
+				s.expectLine("EHLO " + bannerClientName)
+				s.send("250-mail.example.com\r\n250-SIZE 1000\r\n250 STARTTLS\r\n")
+				s.expectLine("QUIT")
+			},
+			wantBanner:  "mail.example.com ESMTP ready",
+			wantPhases:  []string{"banner", "ehlo"},
+			wantDetails: map[string]interface{}{"extensions": []string{"SIZE 1000", "STARTTLS"}},
+		},
+		{
+			name:    "smtp starttls",
+			options: BannerOptions{Protocol: "smtp", StartTLS: true},
+			serve: func(s *bannerTestServer) {
+				s.send("220 mail.example.com ESMTP\r\n")
+				s.expectLine("EHLO " + bannerClientName)
+				s.send("250-mail.example.com\r\n250 STARTTLS\r\n")
+				s.expectLine("STARTTLS")
+				s.send("220 go ahead\r\n")
+				s.startTLS()
+				s.expectLine("EHLO " + bannerClientName)
+				s.send("250-mail.example.com\r\n250 AUTH PLAIN\r\n")
+				s.expectLine("QUIT")
+			},
+			wantBanner:  "mail.example.com ESMTP",
+			wantPhases:  []string{"banner", "ehlo", "starttls", "tls", "ehlo"},
+			wantDetails: map[string]interface{}{"extensions": []string{"AUTH PLAIN"}},
+			wantTLS:     true,
+		},
+		{
+			name:    "smtp implicit tls",
+			options: BannerOptions{Protocol: "smtp", TLS: true},
+			serve: func(s *bannerTestServer) {
+				s.startTLS()
+				s.send("220 mail.example.com ESMTP\r\n")
+				s.expectLine("EHLO " + bannerClientName)
+				s.send("250 mail.example.com\r\n")
+				s.expectLine("QUIT")
+			},
+			wantBanner:  "mail.example.com ESMTP",
+			wantPhases:  []string{"tls", "banner", "ehlo"},
+			wantDetails: map[string]interface{}{},
+			wantTLS:     true,
+		},
+		{
+			name:    "smtp starttls refused",
+			options: BannerOptions{Protocol: "smtp", StartTLS: true},
+			serve: func(s *bannerTestServer) {
+				s.send("220 mail.example.com ESMTP\r\n")
+				s.expectLine("EHLO " + bannerClientName)
+				s.send("250 mail.example.com\r\n")
+				s.expectLine("STARTTLS")
+				s.send("454 TLS not available\r\n")
+			},
+			wantErr:     "unexpected starttls reply: 454 TLS not available",
+			wantBanner:  "mail.example.com ESMTP",
+			wantPhases:  []string{"banner", "ehlo", "starttls"},
+			wantDetails: map[string]interface{}{},
+		},
+		{
+			name:    "smtp malformed reply",
+			options: BannerOptions{Protocol: "smtp"},
+			serve: func(s *bannerTestServer) {
+				s.send("hello\r\n")
+			},
+			wantErr:     "malformed reply",
+			wantDetails: map[string]interface{}{},
+		},
+		{
+			name:    "ftp",
+			options: BannerOptions{Protocol: "ftp"},
+			serve: func(s *bannerTestServer) {
+				s.send("220-Welcome\r\n220-to the archive\r\n220 ready\r\n")
+				s.expectLine("QUIT")
+			},
+			wantBanner:  "Welcome\nto the archive\nready",
+			wantPhases:  []string{"banner"},
+			wantDetails: map[string]interface{}{},
+		},
+		{
+			name:    "ftp auth tls",
+			options: BannerOptions{Protocol: "ftp", StartTLS: true},
+			serve: func(s *bannerTestServer) {
+				s.send("220 ready\r\n")
+				s.expectLine("AUTH TLS")
+				s.send("234 AUTH TLS successful\r\n")
+				s.startTLS()
+				s.expectLine("QUIT")
+			},
+			wantBanner:  "ready",
+			wantPhases:  []string{"banner", "auth", "tls"},
+			wantDetails: map[string]interface{}{},
+			wantTLS:     true,
+		},
+		{
+			name:    "ftp unexpected code",
+			options: BannerOptions{Protocol: "ftp"},
+			serve: func(s *bannerTestServer) {
+				s.send("421 too many connections\r\n")
+			},
+			wantErr:     "unexpected banner reply: 421 too many connections",
+			wantBanner:  "too many connections",
+			wantPhases:  []string{"banner"},
+			wantDetails: map[string]interface{}{},
+		},
+		{
+			name:    "ssh",
+			options: BannerOptions{Protocol: "ssh"},
+			serve: func(s *bannerTestServer) {
+				s.send("please wait\r\nSSH-2.0-OpenSSH_9.6 Ubuntu-3\r\n")
+			},
+			wantBanner: "SSH-2.0-OpenSSH_9.6 Ubuntu-3",
+			wantPhases: []string{"banner"},
+			wantDetails: map[string]interface{}{
+				"protoVersion": "2.0",
+				"software":     "OpenSSH_9.6",
+				"comments":     "Ubuntu-3",
+			},
+		},
+		{
+			name:    "ssh no identification",
+			options: BannerOptions{Protocol: "ssh"},
+			serve: func(s *bannerTestServer) {
+				s.send("HTTP/1.1 400 Bad Request\r\n")
+			},
+			wantErr:     "receive error",
+			wantDetails: map[string]interface{}{},
+		},
+		{
+			name:    "redis",
+			options: BannerOptions{Protocol: "redis"},
+			serve: func(s *bannerTestServer) {
+				s.expectLine("PING")
+				s.send("+PONG\r\n")
+			},
+			wantBanner:  "+PONG",
+			wantPhases:  []string{"ping"},
+			wantDetails: map[string]interface{}{"authRequired": false},
+		},
+		{
+			name:    "redis auth required",
+			options: BannerOptions{Protocol: "redis"},
+			serve: func(s *bannerTestServer) {
+				s.expectLine("PING")
+				s.send("-NOAUTH Authentication required.\r\n")
+			},
+			wantBanner:  "-NOAUTH Authentication required.",
+			wantPhases:  []string{"ping"},
+			wantDetails: map[string]interface{}{"authRequired": true},
+		},
+		{
+			name:    "redis malformed reply",
+			options: BannerOptions{Protocol: "redis"},
+			serve: func(s *bannerTestServer) {
+				s.expectLine("PING")
+				s.send("SSH-2.0-OpenSSH_9.6\r\n")
+			},
+			wantErr:     "unexpected redis reply",
+			wantBanner:  "SSH-2.0-OpenSSH_9.6",
+			wantPhases:  []string{"ping"},
+			wantDetails: map[string]interface{}{},
+		},
+		{
+			name:    "mysql",
+			options: BannerOptions{Protocol: "mysql"},
+			serve: func(s *bannerTestServer) {
+				s.send(mysqlHandshake("8.0.36", 42, mysqlClientProtocol41|mysqlClientSecureConn))
+			},
+			wantBanner: "8.0.36",
+			wantPhases: []string{"banner"},
+			wantDetails: map[string]interface{}{
+				"serverVersion": "8.0.36",
+				"connectionId":  uint32(42),
+				"sslSupported":  false,
+			},
+		},
+		{
+			name:    "mysql ssl request",
+			options: BannerOptions{Protocol: "mysql", StartTLS: true},
+			serve: func(s *bannerTestServer) {
+				s.send(mysqlHandshake("8.0.36", 7, mysqlClientProtocol41|mysqlClientSSL|mysqlClientSecureConn))
+				request := s.read(4 + 32)
+				if request[3] != 1 || binary.LittleEndian.Uint32(request[4:8])&mysqlClientSSL == 0 {
+					s.t.Errorf("unexpected SSL request %x", request)
+				}
+				s.startTLS()
+			},
+			wantBanner: "8.0.36",
+			wantPhases: []string{"banner", "tls"},
+			wantDetails: map[string]interface{}{
+				"serverVersion": "8.0.36",
+				"connectionId":  uint32(7),
+				"sslSupported":  true,
+			},
+			wantTLS: true,
+		},
+		{
+			name:    "mysql ssl unsupported",
+			options: BannerOptions{Protocol: "mysql", StartTLS: true},
+			serve: func(s *bannerTestServer) {
+				s.send(mysqlHandshake("5.5.5-10.11.6-MariaDB", 1, mysqlClientProtocol41))
+			},
+			wantErr:    "server does not support SSL",
+			wantBanner: "5.5.5-10.11.6-MariaDB",
+			wantPhases: []string{"banner"},
+			wantDetails: map[string]interface{}{
+				"serverVersion": "5.5.5-10.11.6-MariaDB",
+				"connectionId":  uint32(1),
+				"sslSupported":  false,
+			},
+		},
+		{
+			name:    "mysql error packet",
+			options: BannerOptions{Protocol: "mysql"},
+			serve: func(s *bannerTestServer) {
+				s.send(mysqlPacket([]byte("\xff\x6a\x04#HY000Host is not allowed to connect")))
+			},
+			wantErr:     "mysql error: Host is not allowed to connect",
+			wantBanner:  "Host is not allowed to connect",
+			wantPhases:  []string{"banner"},
+			wantDetails: map[string]interface{}{},
+		},
+		{
+			name:    "mysql malformed handshake",
+			options: BannerOptions{Protocol: "mysql"},
+			serve: func(s *bannerTestServer) {
+				s.send(mysqlPacket([]byte{mysqlHandshakeProtocolV10, '8', '.', '0'}))
+			},
+			wantErr:     "malformed mysql handshake",
+			wantPhases:  []string{"banner"},
+			wantDetails: map[string]interface{}{},
+		},
+		{
+			name:    "postgresql",
+			options: BannerOptions{Protocol: "postgresql"},
+			serve: func(s *bannerTestServer) {
+				expectPostgresStartup(s)
+				s.send(postgresMessage('R', append([]byte{0, 0, 0, 10}, "SCRAM-SHA-256\x00SCRAM-SHA-256-PLUS\x00\x00"...)))
+			},
+			wantBanner: "authentication request: sasl",
+			wantPhases: []string{"startup"},
+			wantDetails: map[string]interface{}{
+				"authMethod":     "sasl",
+				"saslMechanisms": []string{"SCRAM-SHA-256", "SCRAM-SHA-256-PLUS"},
+			},
+		},
+		{
+			name:    "postgresql ssl request",
+			options: BannerOptions{Protocol: "postgresql", StartTLS: true},
+			serve: func(s *bannerTestServer) {
+				request := s.read(8)
+				if binary.BigEndian.Uint32(request[4:8]) != postgresSSLRequestCode {
+					s.t.Errorf("unexpected SSL request %x", request)
+				}
+				s.send("S")
+				s.startTLS()
+				expectPostgresStartup(s)
+				s.send(postgresMessage('E', []byte("SFATAL\x00C28000\x00Mno pg_hba.conf entry for host\x00\x00")))
+			},
+			wantBanner: "no pg_hba.conf entry for host",
+			wantPhases: []string{"sslrequest", "tls", "startup"},
+			wantDetails: map[string]interface{}{
+				"sslSupported": true,
+				"sqlState":     "28000",
+			},
+			wantTLS: true,
+		},
+		{
+			name:    "postgresql ssl refused",
+			options: BannerOptions{Protocol: "postgresql", StartTLS: true},
+			serve: func(s *bannerTestServer) {
+				s.read(8)
+				s.send("N")
+			},
+			wantErr:     "server refused SSL",
+			wantPhases:  []string{"sslrequest"},
+			wantDetails: map[string]interface{}{"sslSupported": false},
+		},
+		{
+			name:    "postgresql malformed message",
+			options: BannerOptions{Protocol: "postgresql"},
+			serve: func(s *bannerTestServer) {
+				expectPostgresStartup(s)
+				s.send("R\x00\x00\x00\x02")
+			},
+			wantErr:     "malformed postgresql message",
+			wantDetails: map[string]interface{}{},
+		},
+		{
+			name:    "postgresql unexpected message",
+			options: BannerOptions{Protocol: "postgresql"},
+			serve: func(s *bannerTestServer) {
+				expectPostgresStartup(s)
+				s.send(postgresMessage('Z', []byte{'I'}))
+			},
+			wantErr:     "unexpected postgresql message type 'Z'",
+			wantPhases:  []string{"startup"},
+			wantDetails: map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := runBannerTest(t, tt.options, tt.serve)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			if result == nil {
+				t.Fatal("no result returned")
+			}
+			if result.Banner != tt.wantBanner {
+				t.Errorf("banner = %q, want %q", result.Banner, tt.wantBanner)
+			}
+			if names := bannerPhaseNames(result); strings.Join(names, ",") != strings.Join(tt.wantPhases, ",") {
+				t.Errorf("phases = %v, want %v", names, tt.wantPhases)
+			}
+			if !reflect.DeepEqual(result.Details, tt.wantDetails) {
+				t.Errorf("details = %#v, want %#v", result.Details, tt.wantDetails)
+			}
+			if gotTLS := result.TLSVersion != ""; gotTLS != tt.wantTLS {
+				t.Errorf("tls version = %q, want tls %v", result.TLSVersion, tt.wantTLS)
+			}
+		})
+	}
+}
+
+func TestRunBannerProbeUnsupportedProtocol(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if _, err := RunBannerProbe(client, BannerOptions{Protocol: "telnet"}); err == nil {
+		t.Fatal("expected an error for an unsupported protocol")
+	}
+}
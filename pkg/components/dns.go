@@ -0,0 +1,298 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"github.com/miekg/dns"
+)
+
+const dnsDefaultTimeout = 3000
+
+// dnsRecordTypes lists the record types a DNS task may query
+var dnsRecordTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"MX":    dns.TypeMX,
+	"TXT":   dns.TypeTXT,
+	"NS":    dns.TypeNS,
+	"SOA":   dns.TypeSOA,
+	"CAA":   dns.TypeCAA,
+	"PTR":   dns.TypePTR,
+}
+
+// DNSHandler handles DNS lookup tasks
+type DNSHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (dh *DNSHandler) ValidateData(data map[string]interface{}) error {
+	if data["host"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if _, ok := dnsRecordTypes[strings.ToUpper(getStringParam(data, "recordType", "A"))]; !ok {
+		return fmt.Errorf("unsupported record type: %v", data["recordType"])
+	}
+	if !slices.Contains(network.DNSTransports, strings.ToLower(getStringParam(data, "transport", network.DNSTransportUDP))) {
+		return fmt.Errorf("unsupported transport: %v", data["transport"])
+	}
+	if getBoolParam(data, "validate") && getBoolParam(data, "compare") {
+		return fmt.Errorf("validate cannot be combined with compare")
+	}
+	if err := checkIntParam(data, "timeout", 500, 10000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v DNS %v %v\n", clientIP, data["host"], data["recordType"])
+	}
+	return nil
+}
+
+// PreProcess normalizes the query name and resolver address
+func (dh *DNSHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	recordType := strings.ToUpper(getStringParam(data, "recordType", "A"))
+	name, err := dnsQueryName(data["host"].(string), recordType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transport := strings.ToLower(getStringParam(data, "transport", network.DNSTransportUDP))
+	compare := getBoolParam(data, "compare")
+	server := strings.TrimSpace(getStringParam(data, "server", ""))
+	if server != "" {
+		// A comparison reaches the same host on each transport's default port
+		if compare {
+			server = network.DNSServerHost(server)
+		}
+		for _, t := range dnsTransports(transport, compare) {
+			if _, err := network.DNSServerAddress(server, t); err != nil {
+				return nil, nil, err
+			}
+		}
+		if !compare {
+			server, _ = network.DNSServerAddress(server, transport)
+		}
+	}
+
+	validate := getBoolParam(data, "validate")
+	var anchors []*dns.DS
+	if validate {
+		if anchors, err = dnsTrustAnchors(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["name"] = name
+	processedData["recordType"] = recordType
+	processedData["server"] = server
+	processedData["transport"] = transport
+	processedData["compare"] = compare
+	processedData["trustAnchors"] = anchors
+
+	response := map[string]interface{}{
+		"name":       name,
+		"recordType": recordType,
+		"server":     server,
+		"transport":  transport,
+		"compare":    compare,
+		"validate":   validate,
+		"taskType":   dh.GetTaskType(),
+		"taskId":     taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute sends the query and reports every section of the reply, or one reply per transport when comparing
+func (dh *DNSHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	name := data["name"].(string)
+	recordType := data["recordType"].(string)
+	server := data["server"].(string)
+	transport := data["transport"].(string)
+	timeout := time.Duration(getIntParam(data, "timeout", dnsDefaultTimeout)) * time.Millisecond
+	insecure := getBoolParam(data, "insecure")
+
+	servers := []string{server}
+	if server == "" {
+		servers = network.SystemNameservers()
+		// System resolvers are listed with their plain DNS port
+		for i := range servers {
+			servers[i] = network.DNSServerHost(servers[i])
+		}
+	}
+
+	if data["compare"].(bool) {
+		return dh.compareTransports(name, recordType, servers, insecure, timeout, taskId, stopChan, responseSender)
+	}
+
+	result, err := dnsLookup(name, dnsRecordTypes[recordType], servers, transport, insecure, timeout)
+	if err != nil {
+		errorRes := map[string]interface{}{
+			"error":    err.Error(),
+			"taskType": dh.GetTaskType(),
+			"taskId":   taskId,
+		}
+		return responseSender.SendMessage("agent-response", errorRes)
+	}
+
+	res := dnsResultToMap(result)
+	if anchors := data["trustAnchors"].([]*dns.DS); len(anchors) > 0 {
+		res["dnssec"] = validateDNSSEC(name, dnsRecordTypes[recordType], result.Server, transport, insecure, timeout, anchors, stopChan)
+	}
+	res["name"] = name
+	res["recordType"] = recordType
+	res["taskType"] = dh.GetTaskType()
+	res["taskId"] = taskId
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// GetTaskType returns the task type identifier
+func (dh *DNSHandler) GetTaskType() string {
+	return "dns"
+}
+
+// compareTransports sends the same query over every transport in turn so their latencies are comparable
+func (dh *DNSHandler) compareTransports(name string, recordType string, servers []string, insecure bool, timeout time.Duration, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	results := make([]map[string]interface{}, 0, len(network.DNSTransports))
+	fastest := ""
+	var fastestTime time.Duration
+	for _, transport := range network.DNSTransports {
+		select {
+		case <-stopChan:
+			return fmt.Errorf("task %v received stop signal", taskId)
+		default:
+		}
+
+		result, err := dnsLookup(name, dnsRecordTypes[recordType], servers, transport, insecure, timeout)
+		if err != nil {
+			results = append(results, map[string]interface{}{
+				"transport": transport,
+				"error":     err.Error(),
+			})
+			continue
+		}
+		results = append(results, dnsResultToMap(result))
+		if fastest == "" || result.Total < fastestTime {
+			fastest = transport
+			fastestTime = result.Total
+		}
+	}
+
+	res := map[string]interface{}{
+		"name":       name,
+		"recordType": recordType,
+		"results":    results,
+		"fastest":    fastest,
+		"taskType":   dh.GetTaskType(),
+		"taskId":     taskId,
+	}
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// dnsTransports returns the transports a task sends its query over
+func dnsTransports(transport string, compare bool) []string {
+	if compare {
+		return network.DNSTransports
+	}
+	return []string{transport}
+}
+
+// dnsQueryName turns the requested host into a fully qualified query name
+func dnsQueryName(host string, recordType string) (string, error) {
+	host = strings.Trim(host, " \n\"'[]")
+	if recordType == "PTR" && net.ParseIP(host) != nil {
+		return dns.ReverseAddr(host)
+	}
+	if _, ok := dns.IsDomainName(host); !ok || host == "" {
+		return "", fmt.Errorf("invalid domain: %s", host)
+	}
+	return dns.Fqdn(host), nil
+}
+
+// dnsLookup queries the resolvers in order over transport and returns the first reply
+func dnsLookup(name string, qtype uint16, servers []string, transport string, insecure bool, timeout time.Duration) (*network.DNSResult, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no system resolver configured, please specify a server")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.SetEdns0(1232, false)
+
+	var lastErr error
+	for _, server := range servers {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		resolver := network.DNSResolver{
+			Server:             server,
+			Transport:          transport,
+			Timeout:            timeout,
+			InsecureSkipVerify: insecure,
+		}
+		result, err := resolver.Exchange(ctx, msg)
+		cancel()
+		if err == nil {
+			return result, nil
+		}
+		log.Debugf("DNS lookup %s via %s failed: %v", name, server, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// dnsResultToMap converts a DNS reply into the response format
+func dnsResultToMap(result *network.DNSResult) map[string]interface{} {
+	return map[string]interface{}{
+		"server":             result.Server,
+		"transport":          result.Transport,
+		"rcode":              dns.RcodeToString[result.Msg.Rcode],
+		"queryTime":          roundToDecimal(float64(result.Rtt.Microseconds())/1000.0, 3),
+		"connectTime":        roundToDecimal(float64(result.ConnectTime.Microseconds())/1000.0, 3),
+		"tlsTime":            roundToDecimal(float64(result.TLSTime.Microseconds())/1000.0, 3),
+		"totalTime":          roundToDecimal(float64(result.Total.Microseconds())/1000.0, 3),
+		"authoritative":      result.Msg.Authoritative,
+		"truncated":          result.Msg.Truncated,
+		"recursionAvailable": result.Msg.RecursionAvailable,
+		"answer":             dnsRecordsToList(result.Msg.Answer),
+		"authority":          dnsRecordsToList(result.Msg.Ns),
+		"additional":         dnsRecordsToList(result.Msg.Extra),
+	}
+}
+
+// dnsRecordsToList flattens resource records, skipping the EDNS0 pseudo-record
+func dnsRecordsToList(rrs []dns.RR) []map[string]interface{} {
+	records := make([]map[string]interface{}, 0, len(rrs))
+	for _, rr := range rrs {
+		header := rr.Header()
+		if header.Rrtype == dns.TypeOPT {
+			continue
+		}
+		records = append(records, map[string]interface{}{
+			"name":  header.Name,
+			"type":  dns.TypeToString[header.Rrtype],
+			"class": dns.ClassToString[header.Class],
+			"ttl":   header.Ttl,
+			"data":  strings.TrimPrefix(rr.String(), header.String()),
+		})
+	}
+	return records
+}
@@ -0,0 +1,255 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"github.com/miekg/dns"
+)
+
+const dnsPropMaxResolvers = 32
+
+// dnsPropDefaultResolvers are queried when the task names no resolvers, next to the system ones
+var dnsPropDefaultResolvers = []string{
+	"1.1.1.1:53",
+	"8.8.8.8:53",
+	"9.9.9.9:53",
+	"208.67.222.222:53",
+}
+
+// DNSPropHandler handles DNS propagation tasks that compare the answers of several resolvers
+type DNSPropHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (dh *DNSPropHandler) ValidateData(data map[string]interface{}) error {
+	if data["host"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if _, ok := dnsRecordTypes[strings.ToUpper(getStringParam(data, "recordType", "A"))]; !ok {
+		return fmt.Errorf("unsupported record type: %v", data["recordType"])
+	}
+	if len(getStringListParam(data, "servers")) > dnsPropMaxResolvers {
+		return fmt.Errorf("servers must not exceed %d entries", dnsPropMaxResolvers)
+	}
+	if err := checkIntParam(data, "timeout", 500, 10000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v DNSProp %v %v\n", clientIP, data["host"], data["recordType"])
+	}
+	return nil
+}
+
+// PreProcess normalizes the query name and the resolver addresses
+func (dh *DNSPropHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	recordType := strings.ToUpper(getStringParam(data, "recordType", "A"))
+	name, err := dnsQueryName(data["host"].(string), recordType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	servers := getStringListParam(data, "servers")
+	if len(servers) == 0 {
+		servers = append(network.SystemNameservers(), dnsPropDefaultResolvers...)
+	}
+	seen := make(map[string]bool)
+	resolvers := make([]string, 0, len(servers))
+	for _, server := range servers {
+		server = network.NormalizeDNSServer(server, "53")
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return nil, nil, fmt.Errorf("invalid resolver address: %s", server)
+		}
+		if !seen[server] {
+			seen[server] = true
+			resolvers = append(resolvers, server)
+		}
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["name"] = name
+	processedData["recordType"] = recordType
+	processedData["servers"] = resolvers
+
+	response := map[string]interface{}{
+		"name":       name,
+		"recordType": recordType,
+		"servers":    resolvers,
+		"taskType":   dh.GetTaskType(),
+		"taskId":     taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute queries every resolver in parallel and summarizes how far their answers agree
+func (dh *DNSPropHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	name := data["name"].(string)
+	recordType := data["recordType"].(string)
+	servers := data["servers"].([]string)
+	timeout := time.Duration(getIntParam(data, "timeout", dnsDefaultTimeout)) * time.Millisecond
+	qtype := dnsRecordTypes[recordType]
+
+	answers := make([]*dnsPropAnswer, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			answers[i] = queryDNSProp(name, qtype, server, timeout)
+		}(i, server)
+	}
+	wg.Wait()
+
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	results := make([]map[string]interface{}, 0, len(answers))
+	for _, answer := range answers {
+		results = append(results, answer.result)
+	}
+	res := map[string]interface{}{
+		"name":       name,
+		"recordType": recordType,
+		"results":    results,
+		"summary":    summarizeDNSProp(answers),
+		"taskType":   dh.GetTaskType(),
+		"taskId":     taskId,
+	}
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// GetTaskType returns the task type identifier
+func (dh *DNSPropHandler) GetTaskType() string {
+	return "dnsprop"
+}
+
+// dnsPropAnswer is the reply of one resolver reduced to what is compared across resolvers
+type dnsPropAnswer struct {
+	server string
+	// key identifies the answer set, empty when the query failed
+	key    string
+	rcode  string
+	values []string
+	result map[string]interface{}
+}
+
+// queryDNSProp asks a single resolver and extracts its answer set and lowest TTL
+func queryDNSProp(name string, qtype uint16, server string, timeout time.Duration) *dnsPropAnswer {
+	answer := &dnsPropAnswer{server: server}
+	result, err := dnsLookup(name, qtype, []string{server}, network.DNSTransportUDP, false, timeout)
+	if err != nil {
+		answer.result = map[string]interface{}{
+			"server": server,
+			"error":  err.Error(),
+		}
+		return answer
+	}
+
+	// Compare the records of the queried type, a CNAME chain alone is compared as is
+	var matched []dns.RR
+	for _, rr := range result.Msg.Answer {
+		if rr.Header().Rrtype == qtype {
+			matched = append(matched, rr)
+		}
+	}
+	if len(matched) == 0 {
+		matched = result.Msg.Answer
+	}
+
+	var minTTL uint32
+	values := make([]string, 0, len(matched))
+	for i, rr := range matched {
+		header := rr.Header()
+		if i == 0 || header.Ttl < minTTL {
+			minTTL = header.Ttl
+		}
+		values = append(values, dns.TypeToString[header.Rrtype]+" "+strings.TrimPrefix(rr.String(), header.String()))
+	}
+	sort.Strings(values)
+
+	answer.rcode = dns.RcodeToString[result.Msg.Rcode]
+	answer.values = values
+	answer.key = answer.rcode + "|" + strings.Join(values, "|")
+	answer.result = dnsResultToMap(result)
+	answer.result["answerSet"] = values
+	answer.result["ttl"] = minTTL
+	return answer
+}
+
+// summarizeDNSProp groups resolvers by identical answer sets, largest group first.
+// Resolvers outside the largest group are reported as divergent.
+func summarizeDNSProp(answers []*dnsPropAnswer) map[string]interface{} {
+	type group struct {
+		rcode     string
+		values    []string
+		resolvers []string
+	}
+	groups := make(map[string]*group)
+	var order []*group
+	var failed []string
+	for _, answer := range answers {
+		if answer.key == "" {
+			failed = append(failed, answer.server)
+			continue
+		}
+		g := groups[answer.key]
+		if g == nil {
+			g = &group{rcode: answer.rcode, values: answer.values}
+			groups[answer.key] = g
+			order = append(order, g)
+		}
+		g.resolvers = append(g.resolvers, answer.server)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(order[i].resolvers) > len(order[j].resolvers)
+	})
+
+	answerSets := make([]map[string]interface{}, 0, len(order))
+	divergent := []string{}
+	for i, g := range order {
+		answerSets = append(answerSets, map[string]interface{}{
+			"rcode":     g.rcode,
+			"answerSet": g.values,
+			"resolvers": g.resolvers,
+		})
+		if i > 0 {
+			divergent = append(divergent, g.resolvers...)
+		}
+	}
+
+	summary := map[string]interface{}{
+		"resolvers":  len(answers),
+		"answered":   len(answers) - len(failed),
+		"failed":     failed,
+		"consistent": len(order) == 1,
+		"answerSets": answerSets,
+		"divergent":  divergent,
+	}
+	if len(order) > 0 {
+		summary["majority"] = order[0].values
+		summary["agreement"] = roundToDecimal(float64(len(order[0].resolvers))/float64(len(answers)-len(failed))*100, 2)
+	}
+	return summary
+}
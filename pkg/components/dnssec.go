@@ -0,0 +1,124 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// dnsTrustAnchors returns the configured root DS records, the IANA root anchors when none are set
+func dnsTrustAnchors() ([]*dns.DS, error) {
+	text := viper.GetString("dns.trustanchor")
+	if strings.TrimSpace(text) == "" {
+		text = strings.Join(network.RootTrustAnchors, "\n")
+	}
+	return network.ParseTrustAnchors(text)
+}
+
+// validateDNSSEC walks the chain of trust for the query through the resolver that answered it
+func validateDNSSEC(name string, qtype uint16, server string, transport string, insecure bool, timeout time.Duration, anchors []*dns.DS, stopChan <-chan struct{}) map[string]interface{} {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	validator := &network.DNSSECValidator{
+		Resolver: &network.DNSResolver{
+			Server:             server,
+			Transport:          transport,
+			Timeout:            timeout,
+			InsecureSkipVerify: insecure,
+		},
+		TrustAnchors: anchors,
+	}
+	start := time.Now()
+	result := validator.Validate(ctx, name, qtype)
+	res := dnssecResultToMap(result)
+	res["validationTime"] = roundToDecimal(float64(time.Since(start).Microseconds())/1000.0, 3)
+	return res
+}
+
+// dnssecResultToMap converts a validation result into the response format
+func dnssecResultToMap(result *network.DNSSECResult) map[string]interface{} {
+	now := time.Now()
+
+	zones := make([]map[string]interface{}, 0, len(result.Zones))
+	for _, zone := range result.Zones {
+		keys := make([]map[string]interface{}, 0, len(zone.Keys))
+		for _, key := range zone.Keys {
+			keys = append(keys, map[string]interface{}{
+				"keyTag":    key.KeyTag(),
+				"algorithm": dns.AlgorithmToString[key.Algorithm],
+				"flags":     key.Flags,
+				"sep":       key.Flags&dns.SEP != 0,
+			})
+		}
+		ds := make([]map[string]interface{}, 0, len(zone.DS))
+		for _, d := range zone.DS {
+			ds = append(ds, map[string]interface{}{
+				"keyTag":     d.KeyTag,
+				"algorithm":  dns.AlgorithmToString[d.Algorithm],
+				"digestType": dns.HashToString[d.DigestType],
+			})
+		}
+		zones = append(zones, map[string]interface{}{
+			"zone":    zone.Name,
+			"status":  zone.Status,
+			"ds":      ds,
+			"dnskeys": keys,
+		})
+	}
+
+	signatures := make([]map[string]interface{}, 0, len(result.Signatures))
+	for _, sig := range result.Signatures {
+		signature := map[string]interface{}{
+			"owner":      sig.Owner,
+			"type":       dns.TypeToString[sig.Covered],
+			"signer":     sig.Signer,
+			"keyTag":     sig.KeyTag,
+			"algorithm":  dns.AlgorithmToString[sig.Algorithm],
+			"inception":  sig.Inception.Format(time.RFC3339),
+			"expiration": sig.Expiration.Format(time.RFC3339),
+			"expiresIn":  int64(sig.Expiration.Sub(now).Seconds()),
+			"valid":      sig.Valid,
+		}
+		if sig.Error != "" {
+			signature["error"] = sig.Error
+		}
+		signatures = append(signatures, signature)
+	}
+
+	mismatches := make([]map[string]interface{}, 0, len(result.Mismatches))
+	for _, mismatch := range result.Mismatches {
+		mismatches = append(mismatches, map[string]interface{}{
+			"zone":       mismatch.Zone,
+			"keyTag":     mismatch.KeyTag,
+			"algorithm":  dns.AlgorithmToString[mismatch.Algorithm],
+			"digestType": dns.HashToString[mismatch.DigestType],
+			"reason":     mismatch.Reason,
+		})
+	}
+
+	res := map[string]interface{}{
+		"status":     result.Status,
+		"zones":      zones,
+		"signatures": signatures,
+		"mismatches": mismatches,
+	}
+	if result.Reason != "" {
+		res["reason"] = result.Reason
+	}
+	return res
+}
@@ -0,0 +1,326 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionalphapb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+const grpcDefaultTimeout = 5000
+
+// GRPCHandler handles gRPC health check tasks
+type GRPCHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (gh *GRPCHandler) ValidateData(data map[string]interface{}) error {
+	if data["host"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if err := checkIntParam(data, "timeout", 500, 30000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v GRPC %v\n", clientIP, data["host"])
+	}
+	return nil
+}
+
+// PreProcess resolves the target and derives the default SNI
+func (gh *GRPCHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	// Clean host string and remove brackets for IPv6
+	host := strings.Trim(data["host"].(string), " \n\"'")
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = strings.Trim(host, "[]")
+	}
+
+	ip, hostname, port, ipVersion, err := network.FilterIP(host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterIP error: %v", err)
+	}
+
+	// FilterIP falls back to port 80, TLS targets default to 443
+	if _, _, splitErr := net.SplitHostPort(host); splitErr != nil && getBoolParam(data, "tls") {
+		port = "443"
+	}
+
+	// SNI defaults to the hostname, never to a literal IP
+	sni := getStringParam(data, "sni", "")
+	if sni == "" && net.ParseIP(hostname) == nil {
+		sni = hostname
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["ip"] = ip
+	processedData["host"] = hostname
+	processedData["port"] = port
+	processedData["sni"] = sni
+	processedData["ipVersion"] = ipVersion
+
+	response := map[string]interface{}{
+		"ip":        ip,
+		"port":      port,
+		"ipVersion": ipVersion,
+		"taskType":  gh.GetTaskType(),
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute connects to the server, calls the health service and optionally lists services over reflection
+func (gh *GRPCHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	options := GRPCProbeOptions{
+		Addr:       net.JoinHostPort(data["ip"].(string), data["port"].(string)),
+		Authority:  data["host"].(string),
+		SNI:        data["sni"].(string),
+		TLS:        getBoolParam(data, "tls"),
+		Service:    getStringParam(data, "service", ""),
+		Reflection: getBoolParam(data, "reflection"),
+		Timeout:    time.Duration(getIntParam(data, "timeout", grpcDefaultTimeout)) * time.Millisecond,
+	}
+	result, err := probeGRPC(options)
+
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	result["taskType"] = gh.GetTaskType()
+	result["taskId"] = taskId
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	return responseSender.SendMessage("agent-response", result)
+}
+
+// GetTaskType returns the task type identifier
+func (gh *GRPCHandler) GetTaskType() string {
+	return "grpc"
+}
+
+// GRPCProbeOptions configures a gRPC health probe
+type GRPCProbeOptions struct {
+	Addr       string
+	Authority  string
+	SNI        string
+	TLS        bool
+	Service    string
+	Reflection bool
+	Timeout    time.Duration
+}
+
+// grpcTracingCredentials wraps TLS credentials to time the handshake and keep the negotiated state
+type grpcTracingCredentials struct {
+	credentials.TransportCredentials
+	mu      sync.Mutex
+	elapsed time.Duration
+	state   *tls.ConnectionState
+	err     error
+}
+
+// ClientHandshake performs the wrapped handshake and records its duration and state
+func (c *grpcTracingCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	start := time.Now()
+	conn, authInfo, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.elapsed, c.err = time.Since(start), err
+	if info, ok := authInfo.(credentials.TLSInfo); ok {
+		c.state = &info.State
+	}
+	return conn, authInfo, err
+}
+
+// Clone keeps the wrapper so the recorded handshake stays visible
+func (c *grpcTracingCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+// probeGRPC connects to the target, waits for the channel to become ready and calls the health service.
+// The result always holds the timings gathered so far, also when an error is returned.
+func probeGRPC(options GRPCProbeOptions) (map[string]interface{}, error) {
+	result := map[string]interface{}{
+		"tls": options.TLS,
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var connectTime time.Duration
+	var dialErr error
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		dialStart := time.Now()
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		mu.Lock()
+		defer mu.Unlock()
+		connectTime, dialErr = time.Since(dialStart), err
+		return conn, err
+	}
+
+	// Certificates are verified separately so an untrusted chain does not hide the health status
+	var creds *grpcTracingCredentials
+	dialOptions := []grpc.DialOption{grpc.WithContextDialer(dialer), grpc.WithUserAgent("Adm-agent/" + viper.GetString("version"))}
+	if options.TLS {
+		creds = &grpcTracingCredentials{TransportCredentials: credentials.NewTLS(&tls.Config{
+			ServerName:         options.SNI,
+			InsecureSkipVerify: true,
+		})}
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(creds))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if net.ParseIP(options.Authority) == nil {
+		dialOptions = append(dialOptions, grpc.WithAuthority(options.Authority))
+	}
+
+	// The passthrough resolver dials the already vetted IP without another lookup
+	conn, err := grpc.NewClient("passthrough:///"+options.Addr, dialOptions...)
+	if err != nil {
+		return result, fmt.Errorf("create client error: %v", err)
+	}
+	defer conn.Close()
+
+	// Connect explicitly so the health call below measures only the RPC
+	conn.Connect()
+	for state := conn.GetState(); state != connectivity.Ready; state = conn.GetState() {
+		if state == connectivity.TransientFailure || !conn.WaitForStateChange(ctx, state) {
+			mu.Lock()
+			err = dialErr
+			mu.Unlock()
+			if err == nil && creds != nil {
+				creds.mu.Lock()
+				err = creds.err
+				creds.mu.Unlock()
+			}
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err == nil {
+				err = fmt.Errorf("channel is in state %s", state)
+			}
+			result["totalTime"] = elapsedMs(start, time.Now())
+			return result, fmt.Errorf("connect error: %v", err)
+		}
+	}
+	mu.Lock()
+	result["connectTime"] = roundToDecimal(float64(connectTime.Microseconds())/1000.0, 3)
+	mu.Unlock()
+	if creds != nil {
+		creds.mu.Lock()
+		result["tlsTime"] = roundToDecimal(float64(creds.elapsed.Microseconds())/1000.0, 3)
+		if creds.state != nil {
+			host, _, _ := net.SplitHostPort(options.Addr)
+			for key, value := range describeTLSState(*creds.state, options.SNI, host) {
+				result[key] = value
+			}
+		}
+		creds.mu.Unlock()
+	}
+
+	checkStart := time.Now()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: options.Service})
+	result["checkTime"] = elapsedMs(checkStart, time.Now())
+	result["code"] = status.Code(err).String()
+	if err == nil {
+		result["status"] = resp.GetStatus().String()
+	}
+
+	// Reflection still works when the health service is missing
+	if options.Reflection {
+		if services, reflectionErr := listGRPCServices(ctx, conn); reflectionErr != nil {
+			result["reflectionError"] = reflectionErr.Error()
+		} else {
+			result["services"] = services
+		}
+	}
+	result["totalTime"] = elapsedMs(start, time.Now())
+
+	if err != nil {
+		return result, fmt.Errorf("health check error: %s", status.Convert(err).Message())
+	}
+	return result, nil
+}
+
+// listGRPCServices lists the services over server reflection, falling back to the v1alpha API
+func listGRPCServices(ctx context.Context, conn *grpc.ClientConn) ([]string, error) {
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err == nil {
+		if err = stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		}); err == nil {
+			var resp *reflectionpb.ServerReflectionResponse
+			if resp, err = stream.Recv(); err == nil {
+				stream.CloseSend()
+				if errResp := resp.GetErrorResponse(); errResp != nil {
+					return nil, fmt.Errorf("%s", errResp.GetErrorMessage())
+				}
+				var services []string
+				for _, service := range resp.GetListServicesResponse().GetService() {
+					services = append(services, service.GetName())
+				}
+				sort.Strings(services)
+				return services, nil
+			}
+		}
+	}
+	if status.Code(err) != codes.Unimplemented {
+		return nil, err
+	}
+
+	alphaStream, err := reflectionalphapb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := alphaStream.Send(&reflectionalphapb.ServerReflectionRequest{
+		MessageRequest: &reflectionalphapb.ServerReflectionRequest_ListServices{},
+	}); err != nil {
+		return nil, err
+	}
+	resp, err := alphaStream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	alphaStream.CloseSend()
+	if errResp := resp.GetErrorResponse(); errResp != nil {
+		return nil, fmt.Errorf("%s", errResp.GetErrorMessage())
+	}
+	var services []string
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		services = append(services, service.GetName())
+	}
+	sort.Strings(services)
+	return services, nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// httpProbeMethods lists the request methods a probe may use
+var httpProbeMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+	"DELETE":  true,
+	"OPTIONS": true,
+}
+
+// Address resolution modes of a web speed test
+const (
+	// httpResolvePinned connects the original host to the IP resolved in PreProcess
+	httpResolvePinned = "pinned"
+	// httpResolveDial lets every connection resolve its host through the dialer
+	httpResolveDial = "dial"
+)
+
+// Protocol versions a web speed test may use
+const (
+	// httpVersionAuto keeps the HTTP/1.1 transport existing tasks were measured with
+	httpVersionAuto = "auto"
+	httpVersion1    = "1.1"
+	httpVersion2    = "2"
+	httpVersion3    = "3"
+)
+
+// HTTPProbeOptions customizes the request sent by a web speed test and the checks applied to its response
+type HTTPProbeOptions struct {
+	Method       string
+	Headers      map[string]string
+	Body         string
+	UserAgent    string
+	Resolve      string
+	HTTPVersion  string
+	ExpectStatus []string
+	BodyContains string
+	BodyRegex    *regexp.Regexp
+}
+
+// HTTPAssertion is the outcome of one check against the final response
+type HTTPAssertion struct {
+	Type     string `json:"type"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Passed   bool   `json:"passed"`
+}
+
+// parseHTTPProbeOptions reads the optional request and assertion fields of a web speed task
+func parseHTTPProbeOptions(data map[string]interface{}) (HTTPProbeOptions, error) {
+	options := HTTPProbeOptions{
+		Method:       strings.ToUpper(getStringParam(data, "method", "GET")),
+		Body:         getStringParam(data, "body", ""),
+		UserAgent:    getStringParam(data, "userAgent", ""),
+		Resolve:      strings.ToLower(getStringParam(data, "resolve", httpResolvePinned)),
+		HTTPVersion:  getStringParam(data, "httpVersion", httpVersionAuto),
+		BodyContains: getStringParam(data, "bodyContains", ""),
+	}
+	if !httpProbeMethods[options.Method] {
+		return options, fmt.Errorf("unsupported method: %s", options.Method)
+	}
+	if options.Resolve != httpResolvePinned && options.Resolve != httpResolveDial {
+		return options, fmt.Errorf("resolve must be %s or %s", httpResolvePinned, httpResolveDial)
+	}
+
+	if version, ok := data["httpVersion"].(float64); ok {
+		options.HTTPVersion = strconv.FormatFloat(version, 'f', -1, 64)
+	}
+	switch options.HTTPVersion {
+	case httpVersionAuto, httpVersion1, httpVersion2, httpVersion3:
+	default:
+		return options, fmt.Errorf("unsupported httpVersion: %s", options.HTTPVersion)
+	}
+
+	headers, err := parseHTTPHeaders(data["headers"])
+	if err != nil {
+		return options, err
+	}
+	options.Headers = headers
+
+	for _, pattern := range getStatusListParam(data, "expectStatus") {
+		if !isStatusPattern(pattern) {
+			return options, fmt.Errorf("invalid expected status: %s", pattern)
+		}
+		options.ExpectStatus = append(options.ExpectStatus, pattern)
+	}
+
+	if expr := getStringParam(data, "bodyRegex", ""); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return options, fmt.Errorf("invalid body regex: %v", err)
+		}
+		options.BodyRegex = re
+	}
+	return options, nil
+}
+
+// parseHTTPHeaders accepts headers as a JSON object or as "Name: value" lines
+func parseHTTPHeaders(value interface{}) (map[string]string, error) {
+	headers := make(map[string]string)
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for key, val := range v {
+			headers[key] = fmt.Sprint(val)
+		}
+	case string:
+		for _, line := range strings.Split(v, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			key, val, ok := strings.Cut(line, ":")
+			if !ok || strings.TrimSpace(key) == "" {
+				return nil, fmt.Errorf("invalid header line: %s", line)
+			}
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+	default:
+		return nil, fmt.Errorf("headers must be an object or a string")
+	}
+	return headers, nil
+}
+
+// getStatusListParam reads expected status codes given as numbers, strings or a comma separated string
+func getStatusListParam(data map[string]interface{}, key string) []string {
+	if list, ok := data[key].([]interface{}); ok {
+		patterns := make([]string, 0, len(list))
+		for _, item := range list {
+			switch v := item.(type) {
+			case float64:
+				patterns = append(patterns, strconv.Itoa(int(v)))
+			case string:
+				patterns = append(patterns, strings.TrimSpace(v))
+			}
+		}
+		return patterns
+	}
+	if code, ok := data[key].(float64); ok {
+		return []string{strconv.Itoa(int(code))}
+	}
+	return getStringListParam(data, key)
+}
+
+// isStatusPattern accepts a status code such as "204" or a class such as "2xx"
+func isStatusPattern(pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	if len(pattern) != 3 || pattern[0] < '1' || pattern[0] > '5' {
+		return false
+	}
+	if pattern[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(pattern)
+	return err == nil
+}
+
+// matchStatus reports whether a status code matches one of the patterns
+func matchStatus(code int, patterns []string) bool {
+	actual := strconv.Itoa(code)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == actual || (pattern[1:] == "xx" && pattern[0] == actual[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsBody reports whether the response body has to be kept for assertions
+func (o HTTPProbeOptions) needsBody() bool {
+	return o.BodyContains != "" || o.BodyRegex != nil
+}
+
+// check evaluates the assertions against the final response, passing when none are configured
+func (o HTTPProbeOptions) check(statusCode int, body []byte) ([]HTTPAssertion, bool) {
+	assertions := make([]HTTPAssertion, 0, 3)
+	if len(o.ExpectStatus) > 0 {
+		assertions = append(assertions, HTTPAssertion{
+			Type:     "status",
+			Expected: strings.Join(o.ExpectStatus, ","),
+			Actual:   strconv.Itoa(statusCode),
+			Passed:   matchStatus(statusCode, o.ExpectStatus),
+		})
+	}
+	if o.BodyContains != "" {
+		passed := bytes.Contains(body, []byte(o.BodyContains))
+		assertions = append(assertions, HTTPAssertion{
+			Type:     "bodyContains",
+			Expected: o.BodyContains,
+			Actual:   strconv.FormatBool(passed),
+			Passed:   passed,
+		})
+	}
+	if o.BodyRegex != nil {
+		match := o.BodyRegex.Find(body)
+		assertions = append(assertions, HTTPAssertion{
+			Type:     "bodyRegex",
+			Expected: o.BodyRegex.String(),
+			Actual:   string(match),
+			Passed:   match != nil,
+		})
+	}
+
+	passed := true
+	for _, assertion := range assertions {
+		passed = passed && assertion.Passed
+	}
+	return assertions, passed
+}
+
+// decodeHTTPBody undoes the content encoding of a captured response body
+func decodeHTTPBody(body []byte, encoding string) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return body, nil
+	}
+	if err != nil {
+		return body, err
+	}
+	defer reader.Close()
+
+	// A body cut at the download limit decodes partially, keep what was recovered
+	decoded, err := io.ReadAll(reader)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return decoded, err
+}
@@ -0,0 +1,300 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// errProbeTimeout marks a probe that got no answer within the timeout
+var errProbeTimeout = errors.New("probe timeout")
+
+// listenICMP opens a raw ICMP socket, or a datagram ICMP socket when privileged is false
+func listenICMP(isIPv6 bool, privileged bool) (*icmp.PacketConn, error) {
+	listenNetwork, listenAddr := "ip4:icmp", "0.0.0.0"
+	if isIPv6 {
+		listenNetwork, listenAddr = "ip6:ipv6-icmp", "::"
+	}
+	if !privileged {
+		listenNetwork = "udp4"
+		if isIPv6 {
+			listenNetwork = "udp6"
+		}
+	}
+	conn, err := icmp.ListenPacket(listenNetwork, listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen icmp error: %v", err)
+	}
+	return conn, nil
+}
+
+// setICMPConnTTL sets the TTL or hop limit used for packets written to an ICMP socket
+func setICMPConnTTL(conn *icmp.PacketConn, isIPv6 bool, ttl int) error {
+	if isIPv6 {
+		return conn.IPv6PacketConn().SetHopLimit(ttl)
+	}
+	return conn.IPv4PacketConn().SetTTL(ttl)
+}
+
+// readICMPMessage reads and parses the next ICMP message before the deadline
+func readICMPMessage(conn *icmp.PacketConn, buf []byte, isIPv6 bool, deadline time.Time) (*icmp.Message, net.IP, error) {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, nil, err
+	}
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, nil, errProbeTimeout
+		}
+		return nil, nil, err
+	}
+
+	proto := 1
+	if isIPv6 {
+		proto = 58
+	}
+	m, err := icmp.ParseMessage(proto, buf[:n])
+	if err != nil {
+		return &icmp.Message{}, nil, nil
+	}
+
+	var peer net.IP
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		peer = a.IP
+	case *net.UDPAddr:
+		peer = a.IP
+	}
+	return m, peer, nil
+}
+
+// icmpQuotedPacket extracts the transport protocol and header of the datagram quoted in an ICMP error
+func icmpQuotedPacket(m *icmp.Message, isIPv6 bool) (int, []byte, bool) {
+	var data []byte
+	switch body := m.Body.(type) {
+	case *icmp.TimeExceeded:
+		data = body.Data
+	case *icmp.DstUnreach:
+		data = body.Data
+	case *icmp.PacketTooBig:
+		data = body.Data
+	default:
+		return 0, nil, false
+	}
+
+	if isIPv6 {
+		if len(data) < ipv6.HeaderLen {
+			return 0, nil, false
+		}
+		return int(data[6]), data[ipv6.HeaderLen:], true
+	}
+	if len(data) < ipv4.HeaderLen {
+		return 0, nil, false
+	}
+	headerLen := int(data[0]&0x0f) * 4
+	if headerLen < ipv4.HeaderLen || len(data) < headerLen {
+		return 0, nil, false
+	}
+	return int(data[9]), data[headerLen:], true
+}
+
+// quotesEcho reports whether an ICMP error quotes the echo request with the given id and sequence
+func quotesEcho(m *icmp.Message, isIPv6 bool, id int, seq int) bool {
+	proto, payload, ok := icmpQuotedPacket(m, isIPv6)
+	if !ok || (proto != 1 && proto != 58) || len(payload) < 8 {
+		return false
+	}
+	return int(binary.BigEndian.Uint16(payload[4:6])) == id && int(binary.BigEndian.Uint16(payload[6:8])) == seq
+}
+
+// icmpQuotedDst returns the destination of the datagram quoted in an ICMP error, nil when it is cut short
+func icmpQuotedDst(m *icmp.Message, isIPv6 bool) net.IP {
+	var data []byte
+	switch body := m.Body.(type) {
+	case *icmp.TimeExceeded:
+		data = body.Data
+	case *icmp.DstUnreach:
+		data = body.Data
+	case *icmp.PacketTooBig:
+		data = body.Data
+	}
+	if isIPv6 {
+		if len(data) < ipv6.HeaderLen {
+			return nil
+		}
+		return net.IP(data[24:40])
+	}
+	if len(data) < ipv4.HeaderLen {
+		return nil
+	}
+	return net.IP(data[16:20])
+}
+
+// isPacketTooBig reports whether an ICMP error says a packet exceeded the path MTU
+func isPacketTooBig(m *icmp.Message) bool {
+	if m.Type == ipv6.ICMPTypePacketTooBig {
+		return true
+	}
+	// Fragmentation needed and DF set
+	return m.Type == ipv4.ICMPTypeDestinationUnreachable && m.Code == 4
+}
+
+// nextHopMTU returns the MTU reported in a packet too big error, or 0 when the router left it out
+func nextHopMTU(m *icmp.Message, raw []byte) int {
+	if body, ok := m.Body.(*icmp.PacketTooBig); ok {
+		return body.MTU
+	}
+	// RFC 1191 stores the next-hop MTU in the low half of the unused header word
+	if len(raw) >= 8 {
+		return int(binary.BigEndian.Uint16(raw[6:8]))
+	}
+	return 0
+}
+
+// icmpPinger sends ICMP echo requests to a single target and matches the replies
+type icmpPinger struct {
+	dst        net.IP
+	ipv6       bool
+	privileged bool
+	conn       *icmp.PacketConn
+	id         int
+	seq        int
+	payload    []byte
+	buf        []byte
+
+	// Sender and next-hop MTU of the last packet too big error, 0 when not reported
+	tooBigFrom net.IP
+	tooBigMTU  int
+}
+
+// newIcmpPinger opens the ICMP socket used to ping ip with payloads of the given size
+func newIcmpPinger(ip string, size int) (*icmpPinger, error) {
+	dst := net.ParseIP(ip)
+	if dst == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	p := &icmpPinger{
+		dst:        dst,
+		ipv6:       dst.To4() == nil,
+		privileged: network.GetICMPMode() == network.ICMPModePrivileged,
+		id:         rand.Intn(0xffff) + 1,
+		payload:    make([]byte, size),
+		buf:        make([]byte, 65536),
+	}
+	if network.GetICMPMode() == network.ICMPModeUnavailable {
+		return nil, fmt.Errorf("icmp sockets are not permitted for this user")
+	}
+	conn, err := listenICMP(p.ipv6, p.privileged)
+	if err != nil {
+		return nil, err
+	}
+	p.conn = conn
+	return p, nil
+}
+
+// SetTTL limits the TTL or hop limit of outgoing echo requests
+func (p *icmpPinger) SetTTL(ttl int) error {
+	return setICMPConnTTL(p.conn, p.ipv6, ttl)
+}
+
+// SetDontFragment forbids fragmentation of outgoing echo requests
+func (p *icmpPinger) SetDontFragment() error {
+	if p.ipv6 {
+		return network.SetDontFragment(p.conn.IPv6PacketConn().PacketConn, true)
+	}
+	return network.SetDontFragment(p.conn.IPv4PacketConn().PacketConn, false)
+}
+
+// matchID checks the echo identifier, which the kernel rewrites for datagram ICMP sockets
+func (p *icmpPinger) matchID(id int) bool {
+	return !p.privileged || id == p.id
+}
+
+// SetSize changes the payload size of subsequent echo requests
+func (p *icmpPinger) SetSize(size int) {
+	p.payload = make([]byte, size)
+}
+
+// Close releases the ICMP socket
+func (p *icmpPinger) Close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+// Probe sends one echo request and waits for its reply or an ICMP error quoting it
+func (p *icmpPinger) Probe(timeout time.Duration) PingProbe {
+	p.seq = (p.seq + 1) & 0xffff
+	probe := PingProbe{Seq: p.seq, Status: probeStatusTimeout}
+	p.tooBigFrom, p.tooBigMTU = nil, 0
+
+	var msgType icmp.Type = ipv4.ICMPTypeEcho
+	if p.ipv6 {
+		msgType = ipv6.ICMPTypeEchoRequest
+	}
+	msg := icmp.Message{
+		Type: msgType,
+		Body: &icmp.Echo{ID: p.id, Seq: p.seq, Data: p.payload},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		probe.Status = probeStatusUnreachable
+		return probe
+	}
+
+	// Datagram ICMP sockets are addressed like UDP sockets
+	var dst net.Addr = &net.IPAddr{IP: p.dst}
+	if !p.privileged {
+		dst = &net.UDPAddr{IP: p.dst}
+	}
+
+	start := time.Now()
+	if _, err := p.conn.WriteTo(b, dst); err != nil {
+		log.Debugf("Send icmp echo to %s failed: %v", p.dst, err)
+		probe.Status = probeStatusUnreachable
+		if network.IsMsgTooLarge(err) {
+			probe.Status = probeStatusTooBig
+		}
+		return probe
+	}
+
+	deadline := start.Add(timeout)
+	for {
+		m, peer, err := readICMPMessage(p.conn, p.buf, p.ipv6, deadline)
+		if err != nil {
+			if !errors.Is(err, errProbeTimeout) {
+				log.Debugf("Read icmp reply from %s failed: %v", p.dst, err)
+			}
+			return probe
+		}
+		// Raw sockets see every reply to this host, concurrent pingers may even share the id
+		if echo, ok := m.Body.(*icmp.Echo); ok {
+			if (m.Type == ipv4.ICMPTypeEchoReply || m.Type == ipv6.ICMPTypeEchoReply) && p.matchID(echo.ID) && echo.Seq == p.seq && p.dst.Equal(peer) {
+				probe.Status = probeStatusOK
+				probe.RTT = roundToDecimal(float64(time.Since(start).Microseconds())/1000.0, 3)
+				return probe
+			}
+			continue
+		}
+		if quotesEcho(m, p.ipv6, p.id, p.seq) && p.dst.Equal(icmpQuotedDst(m, p.ipv6)) {
+			probe.Status = probeStatusUnreachable
+			if isPacketTooBig(m) {
+				probe.Status = probeStatusTooBig
+				p.tooBigFrom, p.tooBigMTU = peer, nextHopMTU(m, p.buf)
+			}
+			return probe
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	mtrDefaultRounds   = 10
+	mtrDefaultInterval = 1000
+)
+
+// MtrHandler handles continuous path monitoring tasks
+type MtrHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (mh *MtrHandler) ValidateData(data map[string]interface{}) error {
+	if err := validateTraceData(data); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "rounds", 1, 1000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "interval", 500, 60000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v MTR %v\n", clientIP, data["protocol"])
+	}
+	return nil
+}
+
+// PreProcess resolves the target and creates response structure
+func (mh *MtrHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	return prepareTraceTarget(data, mh.GetTaskType())
+}
+
+// Execute traces the path once per round and reports the accumulated hop statistics
+func (mh *MtrHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	ip := data["ip"].(string)
+	protocol := data["protocol"].(string)
+	port, _ := strconv.Atoi(data["port"].(string))
+	maxHops := getIntParam(data, "maxHops", traceDefaultMaxHops)
+	rounds := getIntParam(data, "rounds", mtrDefaultRounds)
+	interval := time.Duration(getIntParam(data, "interval", mtrDefaultInterval)) * time.Millisecond
+	timeout := time.Duration(getIntParam(data, "timeout", traceDefaultTimeout)) * time.Millisecond
+
+	tr, err := newTracer(ip, protocol, port, timeout)
+	if err != nil {
+		errorRes := map[string]interface{}{
+			"error":    err.Error(),
+			"taskType": mh.GetTaskType(),
+			"taskId":   taskId,
+		}
+		return responseSender.SendMessage("agent-response", errorRes)
+	}
+	defer tr.Close()
+
+	stats := make([]*MtrHopStats, 0, maxHops)
+
+	for round := 1; round <= rounds; round++ {
+		startTime := time.Now()
+		// Hops beyond the target are not probed, the path may grow or shrink between rounds
+		lastHop := maxHops
+
+		for ttl := 1; ttl <= lastHop; ttl++ {
+			select {
+			case <-stopChan:
+				return fmt.Errorf("task %v received stop signal", taskId)
+			default:
+			}
+
+			if len(stats) < ttl {
+				stats = append(stats, &MtrHopStats{Hop: ttl})
+			}
+			reply, err := tr.Probe(ttl)
+			if err != nil && !errors.Is(err, errProbeTimeout) {
+				log.Debugf("MTR probe ttl %d failed: %v", ttl, err)
+			}
+			stats[ttl-1].add(reply, err)
+
+			if err == nil && (reply.reached || reply.unreachable) {
+				lastHop = ttl
+				break
+			}
+		}
+
+		hops := make([]map[string]interface{}, 0, lastHop)
+		for _, hop := range stats[:lastHop] {
+			hops = append(hops, hop.toMap())
+		}
+		res := map[string]interface{}{
+			"round":    round,
+			"hops":     hops,
+			"finished": round == rounds,
+			"taskType": mh.GetTaskType(),
+			"taskId":   taskId,
+		}
+		if sendErr := responseSender.SendMessage("agent-response", res); sendErr != nil {
+			return sendErr
+		}
+
+		if round == rounds {
+			break
+		}
+
+		// Keep rounds at least one interval apart
+		if remaining := interval - time.Since(startTime); remaining > 0 {
+			select {
+			case <-stopChan:
+				return fmt.Errorf("task %v received stop signal", taskId)
+			case <-time.After(remaining):
+			}
+		}
+	}
+	return nil
+}
+
+// GetTaskType returns the task type identifier
+func (mh *MtrHandler) GetTaskType() string {
+	return "mtr"
+}
+
+// MtrHopStats accumulates the probe results of one hop across rounds
+type MtrHopStats struct {
+	Hop      int
+	IP       string
+	Sent     int
+	Received int
+	Last     float64
+	Best     float64
+	Worst    float64
+	mean     float64
+	m2       float64
+}
+
+// add records the outcome of one probe
+func (hs *MtrHopStats) add(reply traceReply, err error) {
+	hs.Sent++
+	if err != nil {
+		return
+	}
+
+	rtt := float64(reply.rtt.Microseconds()) / 1000.0
+	hs.IP = reply.peer.String()
+	hs.Received++
+	hs.Last = rtt
+	if hs.Received == 1 || rtt < hs.Best {
+		hs.Best = rtt
+	}
+	if rtt > hs.Worst {
+		hs.Worst = rtt
+	}
+
+	// Welford's online algorithm keeps mean and variance without storing samples
+	delta := rtt - hs.mean
+	hs.mean += delta / float64(hs.Received)
+	hs.m2 += delta * (rtt - hs.mean)
+}
+
+// toMap converts the statistics into the response format
+func (hs *MtrHopStats) toMap() map[string]interface{} {
+	loss := 0.0
+	if hs.Sent > 0 {
+		loss = float64(hs.Sent-hs.Received) / float64(hs.Sent) * 100
+	}
+	stdev := 0.0
+	if hs.Received > 1 {
+		stdev = math.Sqrt(hs.m2 / float64(hs.Received))
+	}
+	return map[string]interface{}{
+		"hop":   hs.Hop,
+		"ip":    hs.IP,
+		"sent":  hs.Sent,
+		"recv":  hs.Received,
+		"loss":  roundToDecimal(loss, 1),
+		"last":  roundToDecimal(hs.Last, 3),
+		"avg":   roundToDecimal(hs.mean, 3),
+		"best":  roundToDecimal(hs.Best, 3),
+		"worst": roundToDecimal(hs.Worst, 3),
+		"stdev": roundToDecimal(stdev, 3),
+	}
+}
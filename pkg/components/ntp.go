@@ -0,0 +1,299 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+)
+
+const (
+	ntpDefaultCount   = 1
+	ntpDefaultTimeout = 2000
+	ntpMaxServers     = 10
+	ntpPacketSize     = 48
+)
+
+// ntpEpochOffset is the number of seconds between the NTP era 0 epoch (1900) and the Unix epoch
+const ntpEpochOffset = 2208988800
+
+// ntpLeapIndicators names the values of the leap indicator field
+var ntpLeapIndicators = []string{"none", "insert", "delete", "unsynchronized"}
+
+// NTPHandler handles NTP clock offset tasks
+type NTPHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (nh *NTPHandler) ValidateData(data map[string]interface{}) error {
+	if data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	servers := ntpServers(data)
+	if len(servers) == 0 {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if len(servers) > ntpMaxServers {
+		return fmt.Errorf("servers must not exceed %d entries", ntpMaxServers)
+	}
+	if err := checkIntParam(data, "count", 1, 8); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "timeout", 500, 10000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v NTP %v\n", clientIP, strings.Join(servers, ","))
+	}
+	return nil
+}
+
+// PreProcess collects the servers to query
+func (nh *NTPHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	taskId := data["taskId"].(string)
+	servers := ntpServers(data)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["servers"] = servers
+
+	response := map[string]interface{}{
+		"servers":  servers,
+		"taskType": nh.GetTaskType(),
+		"taskId":   taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute queries every server concurrently and reports the offsets
+func (nh *NTPHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	servers := data["servers"].([]string)
+	count := getIntParam(data, "count", ntpDefaultCount)
+	timeout := time.Duration(getIntParam(data, "timeout", ntpDefaultTimeout)) * time.Millisecond
+
+	results := make([]map[string]interface{}, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			results[i] = queryNTPServer(server, count, timeout)
+		}(i, server)
+	}
+	wg.Wait()
+
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	// The local clock offset is the median over the servers that answered
+	var offsets []float64
+	for _, result := range results {
+		if offset, ok := result["offset"].(float64); ok {
+			offsets = append(offsets, offset)
+		}
+	}
+
+	res := map[string]interface{}{
+		"results":  results,
+		"taskType": nh.GetTaskType(),
+		"taskId":   taskId,
+	}
+	if len(offsets) > 0 {
+		res["localOffset"] = median(offsets)
+	} else {
+		res["error"] = "no NTP server answered"
+	}
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// GetTaskType returns the task type identifier
+func (nh *NTPHandler) GetTaskType() string {
+	return "ntp"
+}
+
+// ntpServers reads the server list from "servers", falling back to "host"
+func ntpServers(data map[string]interface{}) []string {
+	servers := getStringListParam(data, "servers")
+	if len(servers) == 0 {
+		servers = getStringListParam(data, "host")
+	}
+	return servers
+}
+
+// NTPResponse is a decoded server reply, offsets and delays in milliseconds
+type NTPResponse struct {
+	Leap           int
+	Version        int
+	Stratum        int
+	Precision      int
+	RootDelay      float64
+	RootDispersion float64
+	RefID          string
+	ReferenceTime  time.Time
+	Offset         float64
+	Delay          float64
+}
+
+// queryNTPServer queries server count times and describes the sample with the lowest delay
+func queryNTPServer(server string, count int, timeout time.Duration) map[string]interface{} {
+	result := map[string]interface{}{"server": server}
+
+	ip, _, port, _, err := network.FilterIP(server)
+	if err != nil {
+		result["error"] = fmt.Sprintf("filterIP error: %v", err)
+		return result
+	}
+	// FilterIP falls back to port 80, NTP uses 123 unless given
+	if _, _, splitErr := net.SplitHostPort(server); splitErr != nil {
+		port = "123"
+	}
+	result["ip"] = ip
+
+	var best *NTPResponse
+	for i := 0; i < count; i++ {
+		resp, queryErr := ntpQuery(net.JoinHostPort(ip, port), timeout)
+		if queryErr != nil {
+			err = queryErr
+			continue
+		}
+		if best == nil || resp.Delay < best.Delay {
+			best = resp
+		}
+	}
+	if best == nil {
+		result["error"] = err.Error()
+		return result
+	}
+
+	result["offset"] = best.Offset
+	result["delay"] = best.Delay
+	result["stratum"] = best.Stratum
+	result["refId"] = best.RefID
+	result["leap"] = ntpLeapIndicators[best.Leap]
+	result["version"] = best.Version
+	result["precision"] = best.Precision
+	result["rootDelay"] = best.RootDelay
+	result["rootDispersion"] = best.RootDispersion
+	result["referenceTime"] = best.ReferenceTime.UTC().Format(time.RFC3339Nano)
+	return result
+}
+
+// ntpQuery sends one NTPv4 client request to addr and decodes the reply
+func ntpQuery(addr string, timeout time.Duration) (*NTPResponse, error) {
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial udp error: %v", err)
+	}
+	defer conn.Close()
+
+	request := make([]byte, ntpPacketSize)
+	request[0] = 0x23 // LI 0, version 4, mode 3 (client)
+
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(request[40:48], toNTPTime(t1))
+	if err := conn.SetDeadline(t1.Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return nil, fmt.Errorf("send error: %v", err)
+	}
+
+	reply := make([]byte, 1024)
+	for {
+		n, err := conn.Read(reply)
+		t4 := time.Now()
+		if err != nil {
+			if udpProbeStatus(err) == probeStatusTimeout {
+				return nil, fmt.Errorf("timeout")
+			}
+			return nil, fmt.Errorf("receive error: %v", err)
+		}
+		// Skip datagrams that do not answer this request
+		if n < ntpPacketSize || reply[0]&0x07 != 4 || !bytes.Equal(reply[24:32], request[40:48]) {
+			continue
+		}
+		return parseNTPReply(reply[:n], t1, t4)
+	}
+}
+
+// parseNTPReply decodes a server reply and computes offset and delay from the four timestamps
+func parseNTPReply(reply []byte, t1, t4 time.Time) (*NTPResponse, error) {
+	resp := &NTPResponse{
+		Leap:           int(reply[0] >> 6),
+		Version:        int(reply[0]>>3) & 0x07,
+		Stratum:        int(reply[1]),
+		Precision:      int(int8(reply[3])),
+		RootDelay:      ntpShortToMs(binary.BigEndian.Uint32(reply[4:8])),
+		RootDispersion: ntpShortToMs(binary.BigEndian.Uint32(reply[8:12])),
+		ReferenceTime:  fromNTPTime(binary.BigEndian.Uint64(reply[16:24])),
+	}
+
+	// Stratum 1 and kiss codes carry ASCII, higher strata the upstream IPv4 address or an IPv6 hash
+	refID := reply[12:16]
+	if resp.Stratum <= 1 {
+		resp.RefID = strings.TrimRight(string(refID), "\x00")
+	} else {
+		resp.RefID = net.IP(refID).String()
+	}
+	if resp.Stratum == 0 {
+		return nil, fmt.Errorf("kiss of death: %s", resp.RefID)
+	}
+
+	t2 := fromNTPTime(binary.BigEndian.Uint64(reply[32:40]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(reply[40:48]))
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	delay := t4.Sub(t1) - t3.Sub(t2)
+	resp.Offset = roundToDecimal(float64(offset.Microseconds())/1000.0, 3)
+	resp.Delay = roundToDecimal(float64(delay.Microseconds())/1000.0, 3)
+	return resp, nil
+}
+
+// toNTPTime converts a time into the 64-bit NTP timestamp format
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / 1e9
+	return seconds<<32 | fraction
+}
+
+// fromNTPTime converts a 64-bit NTP timestamp into a time
+func fromNTPTime(ts uint64) time.Time {
+	seconds := int64(ts>>32) - ntpEpochOffset
+	nanos := int64((ts & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(seconds, nanos)
+}
+
+// ntpShortToMs converts the 32-bit NTP short format into milliseconds
+func ntpShortToMs(v uint32) float64 {
+	return roundToDecimal(float64(v)/65536*1000, 3)
+}
+
+// median returns the median of the values
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return roundToDecimal((sorted[mid-1]+sorted[mid])/2, 3)
+	}
+	return sorted[mid]
+}
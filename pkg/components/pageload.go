@@ -0,0 +1,538 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+const (
+	pageloadDefaultMaxResources = 100
+	pageloadDefaultConnsPerHost = 6
+	pageloadDefaultTimeout      = 30000
+	pageloadMaxRedirects        = 5
+	pageloadMaxBodySize         = 5 * 1024 * 1024
+	pageloadConnectTimeout      = 5 * time.Second
+)
+
+// cssURLPattern matches url() references and @import rules in stylesheets
+var cssURLPattern = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")]+?)['"]?\s*\)|@import\s+['"]([^'"]+)['"]`)
+
+// PageloadHandler handles full page load tasks
+type PageloadHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (ph *PageloadHandler) ValidateData(data map[string]interface{}) error {
+	if data["url"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if err := checkIntParam(data, "maxResources", 1, 300); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "connsPerHost", 1, 16); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "timeout", 1000, 60000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v Pageload %v\n", clientIP, data["url"])
+	}
+	return nil
+}
+
+// PreProcess resolves the page host and normalizes the URL
+func (ph *PageloadHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	pageURL := strings.Trim(data["url"].(string), " \n\"'")
+	if !strings.Contains(pageURL, "://") {
+		pageURL = "https://" + pageURL
+	}
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return nil, nil, fmt.Errorf("invalid page URL: %s", pageURL)
+	}
+
+	ip, _, port, ipVersion, err := network.FilterIP(pageURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterIP error: %v", err)
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["ip"] = ip
+	processedData["url"] = pageURL
+
+	response := map[string]interface{}{
+		"ip":        ip,
+		"port":      port,
+		"ipVersion": ipVersion,
+		"taskType":  ph.GetTaskType(),
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute loads the page with its sub-resources and reports a HAR document
+func (ph *PageloadHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	pageURL := data["url"].(string)
+	ip := data["ip"].(string)
+	timeout := time.Duration(getIntParam(data, "timeout", pageloadDefaultTimeout)) * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	loader := newPageLoader(pageURL, ip,
+		getIntParam(data, "maxResources", pageloadDefaultMaxResources),
+		getIntParam(data, "connsPerHost", pageloadDefaultConnsPerHost))
+	defer loader.Close()
+
+	result, err := loader.Load(ctx, pageURL)
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+	if err != nil {
+		errorRes := map[string]interface{}{
+			"error":    err.Error(),
+			"taskType": ph.GetTaskType(),
+			"taskId":   taskId,
+		}
+		return responseSender.SendMessage("agent-response", errorRes)
+	}
+
+	result["taskType"] = ph.GetTaskType()
+	result["taskId"] = taskId
+	return responseSender.SendMessage("agent-response", result)
+}
+
+// GetTaskType returns the task type identifier
+func (ph *PageloadHandler) GetTaskType() string {
+	return "pageload"
+}
+
+// pageLoader fetches a page and its sub-resources, recording each request as a HAR entry
+type pageLoader struct {
+	client       *http.Client
+	transport    *http.Transport
+	userAgent    string
+	maxResources int
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	seen    map[string]bool
+	entries []HAREntry
+}
+
+// newPageLoader creates a loader that connects the page host to ip and limits connections per host
+func newPageLoader(pageURL, ip string, maxResources, connsPerHost int) *pageLoader {
+	pageHost := ""
+	if parsedURL, err := url.Parse(pageURL); err == nil {
+		pageHost = parsedURL.Hostname()
+	}
+
+	dialer := &net.Dialer{Timeout: pageloadConnectTimeout}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			h, p, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to split host port %s: %v", addr, err)
+			}
+			if h == pageHost {
+				addr = net.JoinHostPort(ip, p)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+		ForceAttemptHTTP2:   true,
+		MaxConnsPerHost:     connsPerHost,
+		IdleConnTimeout:     30 * time.Second,
+		TLSHandshakeTimeout: pageloadConnectTimeout,
+	}
+
+	return &pageLoader{
+		client: &http.Client{
+			Transport: transport,
+			// Redirects are followed by the loader so every hop gets its own entry
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		transport:    transport,
+		userAgent:    getRandomUserAgent(),
+		maxResources: maxResources,
+		seen:         make(map[string]bool),
+	}
+}
+
+// Close releases idle connections
+func (pl *pageLoader) Close() {
+	pl.transport.CloseIdleConnections()
+}
+
+// Load fetches the page, then its sub-resources concurrently, and builds the response
+func (pl *pageLoader) Load(ctx context.Context, pageURL string) (map[string]interface{}, error) {
+	start := time.Now()
+	pl.seen[pageURL] = true
+
+	finalURL, body, contentType, status, err := pl.fetch(ctx, pageURL, "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	if err != nil {
+		return nil, err
+	}
+	// Without a browser engine the content load event is the arrival of the full document
+	contentLoaded := time.Since(start)
+
+	title := pageURL
+	if isMimeType(contentType, "text/html") {
+		var resources []string
+		title, resources = parseHTMLResources(body, finalURL)
+		if title == "" {
+			title = pageURL
+		}
+		for _, resource := range resources {
+			pl.queue(ctx, resource)
+		}
+	}
+	pl.wg.Wait()
+	loaded := time.Since(start)
+
+	pl.mu.Lock()
+	entries := pl.entries
+	pl.mu.Unlock()
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].started.Before(entries[j].started) })
+
+	var totalSize int64
+	failed := 0
+	for _, entry := range entries {
+		totalSize += entry.Response.Content.Size
+		if entry.Error != "" || entry.Response.Status >= 400 {
+			failed++
+		}
+	}
+
+	har := HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "adm-agent", Version: viper.GetString("version")},
+		Pages: []HARPage{{
+			StartedDateTime: start.Format(harTimeFormat),
+			ID:              "page_1",
+			Title:           title,
+			PageTimings: HARPageTimings{
+				OnContentLoad: roundToDecimal(float64(contentLoaded.Microseconds())/1000.0, 3),
+				OnLoad:        roundToDecimal(float64(loaded.Microseconds())/1000.0, 3),
+			},
+		}},
+		Entries: entries,
+	}}
+
+	return map[string]interface{}{
+		"httpCode":      status,
+		"onContentLoad": har.Log.Pages[0].PageTimings.OnContentLoad,
+		"onLoad":        har.Log.Pages[0].PageTimings.OnLoad,
+		"requests":      len(entries),
+		"failed":        failed,
+		"totalSize":     totalSize,
+		"har":           har,
+	}, nil
+}
+
+// queue fetches a sub-resource in the background unless it was seen or the limit is reached
+func (pl *pageLoader) queue(ctx context.Context, resource string) {
+	pl.mu.Lock()
+	if pl.seen[resource] || len(pl.seen) > pl.maxResources {
+		pl.mu.Unlock()
+		return
+	}
+	pl.seen[resource] = true
+	pl.mu.Unlock()
+
+	pl.wg.Add(1)
+	go func() {
+		defer pl.wg.Done()
+		finalURL, body, contentType, _, err := pl.fetch(ctx, resource, "*/*")
+		if err != nil {
+			return
+		}
+		// Stylesheets pull in fonts, images and further stylesheets
+		if isMimeType(contentType, "text/css") {
+			for _, ref := range parseCSSResources(body, finalURL) {
+				pl.queue(ctx, ref)
+			}
+		}
+	}()
+}
+
+// fetch requests rawURL and follows redirects, recording one entry per request
+func (pl *pageLoader) fetch(ctx context.Context, rawURL string, accept string) (*url.URL, []byte, string, int, error) {
+	for i := 0; ; i++ {
+		entry, resp, body, err := pl.request(ctx, rawURL, accept)
+		pl.mu.Lock()
+		pl.entries = append(pl.entries, entry)
+		pl.mu.Unlock()
+		if err != nil {
+			return nil, nil, "", 0, err
+		}
+
+		location := entry.Response.RedirectURL
+		if location == "" || i >= pageloadMaxRedirects {
+			return resp.Request.URL, body, entry.Response.Content.MimeType, resp.StatusCode, nil
+		}
+		rawURL = location
+	}
+}
+
+// request performs a single request and times it with httptrace
+func (pl *pageLoader) request(ctx context.Context, rawURL string, accept string) (HAREntry, *http.Response, []byte, error) {
+	entry := HAREntry{
+		Pageref: "page_1",
+		Request: HARRequest{
+			Method:      http.MethodGet,
+			URL:         rawURL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []HARNameValue{},
+			Headers:     []HARNameValue{},
+			QueryString: []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: HARResponse{
+			Cookies:     []HARNameValue{},
+			Headers:     []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+	}
+
+	// Trace events may fire from dialing goroutines, so guard the timestamps
+	var mu sync.Mutex
+	var getConn, dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone, gotConn, wroteRequest, firstByte time.Time
+	at := func(t *time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		if t.IsZero() {
+			*t = time.Now()
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		GetConn:           func(string) { at(&getConn) },
+		DNSStart:          func(httptrace.DNSStartInfo) { at(&dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { at(&dnsDone) },
+		ConnectStart:      func(string, string) { at(&connectStart) },
+		ConnectDone:       func(string, string, error) { at(&connectDone) },
+		TLSHandshakeStart: func() { at(&tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { at(&tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			at(&gotConn)
+			mu.Lock()
+			defer mu.Unlock()
+			if info.Conn != nil {
+				entry.ServerIPAddress, _, _ = net.SplitHostPort(info.Conn.RemoteAddr().String())
+				_, entry.Connection, _ = net.SplitHostPort(info.Conn.LocalAddr().String())
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { at(&wroteRequest) },
+		GotFirstResponseByte: func() { at(&firstByte) },
+	}
+
+	start := time.Now()
+	entry.started = start
+	entry.StartedDateTime = start.Format(harTimeFormat)
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, rawURL, nil)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry, nil, nil, err
+	}
+	req.Header.Set("User-Agent", pl.userAgent)
+	req.Header.Set("Accept", accept)
+	entry.Request.Headers = harHeaders(req.Header)
+	entry.Request.QueryString = harQueryString(req.URL)
+
+	resp, err := pl.client.Do(req)
+	var body []byte
+	if err == nil {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, pageloadMaxBodySize))
+		resp.Body.Close()
+	}
+	end := time.Now()
+
+	mu.Lock()
+	defer mu.Unlock()
+	fillHARTimings(&entry, start, end, getConn, dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone, gotConn, wroteRequest, firstByte)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry, nil, nil, err
+	}
+
+	entry.Request.HTTPVersion = resp.Proto
+	entry.Request.Cookies = harCookies(req.Cookies())
+	entry.Response.Status = resp.StatusCode
+	entry.Response.StatusText = strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode)))
+	entry.Response.HTTPVersion = resp.Proto
+	entry.Response.Cookies = harCookies(resp.Cookies())
+	entry.Response.Headers = harHeaders(resp.Header)
+	entry.Response.Content = HARContent{Size: int64(len(body)), MimeType: resp.Header.Get("Content-Type")}
+	if !resp.Uncompressed && resp.ContentLength >= 0 {
+		entry.Response.BodySize = resp.ContentLength
+	}
+	if location, err := resp.Location(); err == nil && resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		entry.Response.RedirectURL = location.String()
+	}
+	return entry, resp, body, nil
+}
+
+// fillHARTimings splits the request time into HAR phases from the trace timestamps
+func fillHARTimings(entry *HAREntry, start, end, getConn, dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone, gotConn, wroteRequest, firstByte time.Time) {
+	// Time spent waiting for a connection before resolving or dialing
+	blockedEnd := gotConn
+	for _, t := range []time.Time{connectStart, dnsStart} {
+		if !t.IsZero() {
+			blockedEnd = t
+		}
+	}
+	entry.Timings.Blocked = harDuration(getConn, blockedEnd)
+	entry.Timings.DNS = harDuration(dnsStart, dnsDone)
+
+	// HAR includes the TLS handshake in the connect phase
+	connectEnd := connectDone
+	if !tlsDone.IsZero() {
+		connectEnd = tlsDone
+	}
+	entry.Timings.Connect = harDuration(connectStart, connectEnd)
+	entry.Timings.SSL = harDuration(tlsStart, tlsDone)
+
+	entry.Timings.Send = nonNegative(harDuration(gotConn, wroteRequest))
+	entry.Timings.Wait = nonNegative(harDuration(wroteRequest, firstByte))
+	entry.Timings.Receive = nonNegative(harDuration(firstByte, end))
+	entry.Time = roundToDecimal(float64(end.Sub(start).Microseconds())/1000.0, 3)
+}
+
+// nonNegative maps an unknown duration to zero for phases HAR requires to be non-negative
+func nonNegative(d float64) float64 {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// isMimeType reports whether a Content-Type header carries the given media type
+func isMimeType(contentType, want string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.EqualFold(mediaType, want)
+}
+
+// parseHTMLResources returns the page title and the absolute URLs of linked stylesheets, scripts and images
+func parseHTMLResources(body []byte, base *url.URL) (string, []string) {
+	var title string
+	var resources []string
+	inTitle := false
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			return title, resources
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		case html.EndTagToken:
+			inTitle = false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			attrs := make(map[string]string, len(token.Attr))
+			for _, attr := range token.Attr {
+				attrs[strings.ToLower(attr.Key)] = attr.Val
+			}
+
+			var ref string
+			switch token.Data {
+			case "title":
+				inTitle = tokenType == html.StartTagToken
+			case "base":
+				if u := resolveResourceURL(base, attrs["href"]); u != nil {
+					base = u
+				}
+			case "link":
+				rel := strings.ToLower(attrs["rel"])
+				if strings.Contains(rel, "stylesheet") || strings.Contains(rel, "icon") || strings.Contains(rel, "preload") {
+					ref = attrs["href"]
+				}
+			case "script", "img", "source", "embed":
+				ref = attrs["src"]
+			case "video":
+				ref = attrs["poster"]
+			}
+			if u := resolveResourceURL(base, ref); u != nil {
+				resources = append(resources, u.String())
+			}
+		}
+	}
+}
+
+// parseCSSResources returns the absolute URLs referenced by a stylesheet
+func parseCSSResources(body []byte, base *url.URL) []string {
+	var resources []string
+	for _, match := range cssURLPattern.FindAllSubmatch(body, -1) {
+		ref := string(match[1])
+		if ref == "" {
+			ref = string(match[2])
+		}
+		if u := resolveResourceURL(base, ref); u != nil {
+			resources = append(resources, u.String())
+		}
+	}
+	return resources
+}
+
+// resolveResourceURL resolves a reference against base, keeping only http and https URLs
+func resolveResourceURL(base *url.URL, ref string) *url.URL {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return nil
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+	u.Fragment = ""
+	return u
+}
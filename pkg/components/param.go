@@ -0,0 +1,99 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// getIntParam reads an optional integer field, accepting JSON numbers and numeric strings
+func getIntParam(data map[string]interface{}, key string, defaultValue int) int {
+	switch v := data[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// getStringParam reads an optional string field
+func getStringParam(data map[string]interface{}, key string, defaultValue string) string {
+	if v, ok := data[key].(string); ok && v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// checkIntParam validates that an optional integer field lies within [min, max]
+func checkIntParam(data map[string]interface{}, key string, min int, max int) error {
+	if data[key] == nil {
+		return nil
+	}
+	var n int
+	switch v := data[key].(type) {
+	case float64:
+		if v != float64(int(v)) {
+			return fmt.Errorf("%s must be an integer", key)
+		}
+		n = int(v)
+	case string:
+		var err error
+		n, err = strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("%s must be an integer", key)
+		}
+	default:
+		return fmt.Errorf("%s must be an integer", key)
+	}
+	if n < min || n > max {
+		return fmt.Errorf("%s must be between %d and %d", key, min, max)
+	}
+	return nil
+}
+
+// getBoolParam reads an optional boolean field, accepting JSON booleans and "true"/"yes"/"1"
+func getBoolParam(data map[string]interface{}, key string) bool {
+	switch v := data[key].(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		v = strings.ToLower(strings.TrimSpace(v))
+		return v == "true" || v == "yes" || v == "1"
+	}
+	return false
+}
+
+// getStringListParam reads an optional list field given as a JSON array or a comma separated string
+func getStringListParam(data map[string]interface{}, key string) []string {
+	var items []string
+	switch v := data[key].(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+	case []string:
+		items = v
+	case string:
+		items = strings.Split(v, ",")
+	}
+
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -6,12 +6,22 @@ package components
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"strings"
 	"time"
 
 	"github.com/admuu/adm-agent/pkg/network"
-	"github.com/go-ping/ping"
+)
+
+const (
+	pingDefaultCount           = 3
+	pingDefaultContinuousCount = 100
+	pingMaxCount               = 100
+	pingMaxContinuousCount     = 86400
+	pingDefaultInterval        = 1000
+	pingDefaultTimeout         = 800
+	pingDefaultSize            = 56
 )
 
 // PingHandler handles ping task operations
@@ -22,6 +32,30 @@ func (ph *PingHandler) ValidateData(data map[string]interface{}) error {
 	if data["host"] == nil || data["pingtype"] == nil || data["protocol"] == nil || data["taskId"] == nil {
 		return fmt.Errorf("event data format invalid: missing required fields")
 	}
+	if data["protocol"] != "icmp" && data["protocol"] != "tcp" {
+		return fmt.Errorf("unsupported protocol: %v", data["protocol"])
+	}
+
+	// Bound tunable parameters so a task cannot flood the target or pin the agent
+	maxCount := pingMaxCount
+	if data["pingtype"] == "continuous" {
+		maxCount = pingMaxContinuousCount
+	}
+	if err := checkIntParam(data, "count", 1, maxCount); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "interval", 500, 60000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "timeout", 100, 5000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "size", 0, 65000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "ttl", 1, 255); err != nil {
+		return err
+	}
 	if clientIP := data["clientIP"]; clientIP != nil {
 		log.Infof("%v Ping %v %v\n", clientIP, data["protocol"], data["pingtype"])
 	}
@@ -74,19 +108,28 @@ func (ph *PingHandler) PreProcess(data map[string]interface{}) (map[string]inter
 
 // Execute performs ping operations based on protocol and ping type
 func (ph *PingHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
-	var pingCount = 3
 	var loopCount = 1
-	var delay float32
+	var stats *PingStats
 	var err error
 
 	ip := data["ip"].(string)
 	protocol := data["protocol"].(string)
 	pingtype := data["pingtype"].(string)
+	interval := time.Duration(getIntParam(data, "interval", pingDefaultInterval)) * time.Millisecond
+
+	options := PingOptions{
+		Count:        getIntParam(data, "count", pingDefaultCount),
+		Timeout:      time.Duration(getIntParam(data, "timeout", pingDefaultTimeout)) * time.Millisecond,
+		Size:         getIntParam(data, "size", pingDefaultSize),
+		TTL:          getIntParam(data, "ttl", 0),
+		DontFragment: getBoolParam(data, "dontFragment"),
+		Interval:     interval,
+	}
 
-	// Set parameters for continuous ping
+	// Continuous ping sends one probe per round, count sets the number of rounds
 	if pingtype == "continuous" {
-		pingCount = 1
-		loopCount = 100
+		loopCount = getIntParam(data, "count", pingDefaultContinuousCount)
+		options.Count = 1
 	}
 
 	// Execute ping operations
@@ -99,32 +142,41 @@ func (ph *PingHandler) Execute(data map[string]interface{}, taskId string, stopC
 
 			// Choose ping method based on protocol
 			if protocol == "icmp" {
-				delay, err = IcmpPing(ip, pingCount)
+				stats, err = IcmpPing(ip, options, stopChan)
 			} else if protocol == "tcp" {
-				delay, err = TcpPing(data)
+				stats, err = TcpPing(data, options, stopChan)
 			}
-
-			// Set delay to 0 on error
-			if err != nil {
-				delay = 0
+			if errors.Is(err, errPingStopped) {
+				return fmt.Errorf("task %v received stop signal", taskId)
 			}
 
-			// Send response with delay result
+			// Send response with the round statistics
 			res := map[string]interface{}{
-				"delay":    delay,
+				"delay":    0,
 				"taskType": ph.GetTaskType(),
 				"taskId":   taskId,
 			}
+			if err != nil {
+				res["error"] = err.Error()
+			} else if stats != nil {
+				for k, v := range stats.toMap() {
+					res[k] = v
+				}
+			}
 
 			if sendErr := responseSender.SendMessage("agent-response", res); sendErr != nil {
 				return sendErr
 			}
 
-			// Ensure minimum 1 second interval between pings
-			duration := time.Since(startTime)
-			if duration < 1*time.Second {
-				remainingTime := 1*time.Second - duration
-				time.Sleep(remainingTime)
+			// Keep rounds at least one interval apart
+			if i < loopCount-1 {
+				if remainingTime := interval - time.Since(startTime); remainingTime > 0 {
+					select {
+					case <-stopChan:
+						return fmt.Errorf("task %v received stop signal", taskId)
+					case <-time.After(remainingTime):
+					}
+				}
 			}
 		}
 	}
@@ -136,45 +188,193 @@ func (ph *PingHandler) GetTaskType() string {
 	return "ping"
 }
 
-// IcmpPing performs ICMP ping and returns average delay in milliseconds
-func IcmpPing(ip string, count int) (float32, error) {
-	pinger, err := ping.NewPinger(ip)
-	if err != nil {
-		return 0, err
+// Probe statuses reported for every ping probe
+const (
+	probeStatusOK          = "ok"
+	probeStatusTimeout     = "timeout"
+	probeStatusUnreachable = "unreachable"
+	probeStatusRefused     = "refused"
+	probeStatusTooBig      = "toobig"
+)
+
+// PingProbe is the outcome of a single ping probe
+type PingProbe struct {
+	Seq    int     `json:"seq"`
+	RTT    float64 `json:"rtt"`
+	Status string  `json:"status"`
+}
+
+// PingStats summarizes a round of ping probes, times in milliseconds
+type PingStats struct {
+	Sent     int
+	Received int
+	Loss     float64
+	Min      float64
+	Avg      float64
+	Max      float64
+	Mdev     float64
+	Jitter   float64
+	Probes   []PingProbe
+}
+
+// newPingStats computes loss and RTT statistics over the probes
+func newPingStats(probes []PingProbe) *PingStats {
+	stats := &PingStats{Sent: len(probes), Probes: probes}
+	var sum, sumSquares, jitterSum, prev float64
+
+	for _, probe := range probes {
+		if probe.Status != probeStatusOK {
+			continue
+		}
+		rtt := probe.RTT
+		if stats.Received == 0 || rtt < stats.Min {
+			stats.Min = rtt
+		}
+		if rtt > stats.Max {
+			stats.Max = rtt
+		}
+		// Jitter is the mean difference between consecutive replies
+		if stats.Received > 0 {
+			jitterSum += math.Abs(rtt - prev)
+		}
+		prev = rtt
+		sum += rtt
+		sumSquares += rtt * rtt
+		stats.Received++
 	}
 
-	pinger.SetPrivileged(true)
-	pinger.Count = count
-	pinger.Timeout = 800 * time.Millisecond
+	if stats.Sent > 0 {
+		stats.Loss = roundToDecimal(float64(stats.Sent-stats.Received)/float64(stats.Sent)*100, 2)
+	}
+	if stats.Received > 0 {
+		avg := sum / float64(stats.Received)
+		stats.Avg = roundToDecimal(avg, 3)
+		stats.Mdev = roundToDecimal(math.Sqrt(math.Max(sumSquares/float64(stats.Received)-avg*avg, 0)), 3)
+	}
+	if stats.Received > 1 {
+		stats.Jitter = roundToDecimal(jitterSum/float64(stats.Received-1), 3)
+	}
+	return stats
+}
+
+// toMap converts the statistics into the response format
+func (ps *PingStats) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"delay":    float32(ps.Avg),
+		"sent":     ps.Sent,
+		"received": ps.Received,
+		"loss":     ps.Loss,
+		"min":      ps.Min,
+		"avg":      ps.Avg,
+		"max":      ps.Max,
+		"mdev":     ps.Mdev,
+		"jitter":   ps.Jitter,
+		"probes":   ps.Probes,
+	}
+}
+
+// PingOptions tunes the probes sent by IcmpPing and TcpPing
+type PingOptions struct {
+	Count        int
+	Timeout      time.Duration
+	Size         int
+	TTL          int
+	DontFragment bool
+	// Interval is the least time between the starts of consecutive probes
+	Interval time.Duration
+}
+
+// errPingStopped is returned when the stop channel closes in the middle of a round
+var errPingStopped = errors.New("ping stopped")
+
+// waitProbeInterval waits until interval has passed since start, false when stopChan closes first
+func waitProbeInterval(start time.Time, interval time.Duration, stopChan <-chan struct{}) bool {
+	remainingTime := interval - time.Since(start)
+	if remainingTime <= 0 {
+		select {
+		case <-stopChan:
+			return false
+		default:
+			return true
+		}
+	}
+	select {
+	case <-stopChan:
+		return false
+	case <-time.After(remainingTime):
+		return true
+	}
+}
 
-	err = pinger.Run()
+// IcmpPing sends ICMP echo requests and returns their statistics, it gives up with errPingStopped once stopChan closes
+func IcmpPing(ip string, options PingOptions, stopChan <-chan struct{}) (*PingStats, error) {
+	pinger, err := newIcmpPinger(ip, options.Size)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	defer pinger.Close()
 
-	stats := pinger.Statistics()
-	if stats.PacketsRecv == 0 {
-		return 0, errors.New("PacketsRecv 0")
+	if options.TTL > 0 {
+		if err := pinger.SetTTL(options.TTL); err != nil {
+			return nil, fmt.Errorf("set ttl error: %v", err)
+		}
+	}
+	if options.DontFragment {
+		if err := pinger.SetDontFragment(); err != nil {
+			return nil, fmt.Errorf("set don't fragment error: %v", err)
+		}
 	}
 
-	// Convert average RTT from microseconds to milliseconds
-	delay := float32(stats.AvgRtt.Microseconds()) / 1000.0
-	return delay, nil
+	probes := make([]PingProbe, 0, options.Count)
+	for i := 0; i < options.Count; i++ {
+		startTime := time.Now()
+		probes = append(probes, pinger.Probe(options.Timeout))
+		if i < options.Count-1 && !waitProbeInterval(startTime, options.Interval, stopChan) {
+			return nil, errPingStopped
+		}
+	}
+	return newPingStats(probes), nil
 }
 
-// TcpPing performs TCP connection test and returns delay in milliseconds
-func TcpPing(data map[string]interface{}) (float32, error) {
+// TcpPing performs TCP connection tests and returns their statistics, it gives up with errPingStopped once stopChan closes
+func TcpPing(data map[string]interface{}, options PingOptions, stopChan <-chan struct{}) (*PingStats, error) {
 	ip := data["ip"].(string)
 	port := data["port"].(string)
 
-	startTime := time.Now()
-	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, port), 800*time.Millisecond)
-	if err != nil {
-		return 0, err
+	dialer := &net.Dialer{Timeout: options.Timeout}
+	if options.TTL > 0 {
+		dialer.Control = network.TTLControl(options.TTL)
 	}
-	conn.Close()
 
-	// Convert elapsed time from microseconds to milliseconds
-	delay := float32(time.Since(startTime).Microseconds()) / 1000.0
-	return delay, nil
-}
\ No newline at end of file
+	probes := make([]PingProbe, 0, options.Count)
+	for i := 0; i < options.Count; i++ {
+		probe := PingProbe{Seq: i + 1}
+		startTime := time.Now()
+		conn, err := dialer.Dial("tcp", net.JoinHostPort(ip, port))
+		probe.Status = tcpProbeStatus(err)
+		if err == nil {
+			conn.Close()
+			// Convert elapsed time from microseconds to milliseconds
+			probe.RTT = roundToDecimal(float64(time.Since(startTime).Microseconds())/1000.0, 3)
+		}
+		probes = append(probes, probe)
+		if i < options.Count-1 && !waitProbeInterval(startTime, options.Interval, stopChan) {
+			return nil, errPingStopped
+		}
+	}
+	return newPingStats(probes), nil
+}
+
+// tcpProbeStatus classifies the result of a TCP connection attempt
+func tcpProbeStatus(err error) string {
+	if err == nil {
+		return probeStatusOK
+	}
+	if network.IsConnRefused(err) {
+		return probeStatusRefused
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return probeStatusTimeout
+	}
+	return probeStatusUnreachable
+}
@@ -0,0 +1,223 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+)
+
+const (
+	pingBatchMaxTargets         = 1000
+	pingBatchDefaultConcurrency = 16
+	pingBatchMaxConcurrency     = 64
+)
+
+// PingBatchHandler handles ping tasks that probe many targets with a bounded worker pool
+type PingBatchHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (ph *PingBatchHandler) ValidateData(data map[string]interface{}) error {
+	if data["targets"] == nil || data["protocol"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if data["protocol"] != "icmp" && data["protocol"] != "tcp" {
+		return fmt.Errorf("unsupported protocol: %v", data["protocol"])
+	}
+	targets := getStringListParam(data, "targets")
+	if len(targets) == 0 {
+		return fmt.Errorf("targets must not be empty")
+	}
+	if len(targets) > pingBatchMaxTargets {
+		return fmt.Errorf("targets must not exceed %d entries", pingBatchMaxTargets)
+	}
+	if err := checkIntParam(data, "count", 1, pingMaxCount); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "timeout", 100, 5000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "size", 0, 65000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "ttl", 1, 255); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "concurrency", 1, pingBatchMaxConcurrency); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v PingBatch %v %v targets\n", clientIP, data["protocol"], len(targets))
+	}
+	return nil
+}
+
+// PreProcess cleans the target list, resolving is left to the workers so the acknowledgement is not delayed
+func (ph *PingBatchHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	seen := make(map[string]bool)
+	var targets []string
+	for _, target := range getStringListParam(data, "targets") {
+		target = strings.Trim(target, " \n\"'")
+		if target == "" || seen[target] {
+			continue
+		}
+		seen[target] = true
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil, nil, fmt.Errorf("targets must not be empty")
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["targets"] = targets
+
+	response := map[string]interface{}{
+		"targets":  len(targets),
+		"protocol": data["protocol"],
+		"taskType": ph.GetTaskType(),
+		"taskId":   taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute pings the targets concurrently and streams one response per target as it completes
+func (ph *PingBatchHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	targets := data["targets"].([]string)
+	protocol := data["protocol"].(string)
+	workers := min(getIntParam(data, "concurrency", pingBatchDefaultConcurrency), len(targets))
+
+	options := PingOptions{
+		Count:        getIntParam(data, "count", pingDefaultCount),
+		Timeout:      time.Duration(getIntParam(data, "timeout", pingDefaultTimeout)) * time.Millisecond,
+		Size:         getIntParam(data, "size", pingDefaultSize),
+		TTL:          getIntParam(data, "ttl", 0),
+		DontFragment: getBoolParam(data, "dontFragment"),
+	}
+
+	jobs := make(chan string)
+	results := make(chan map[string]interface{})
+	go func() {
+		defer close(jobs)
+		for _, target := range targets {
+			select {
+			case <-stopChan:
+				return
+			case jobs <- target:
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range jobs {
+				results <- pingBatchTarget(target, protocol, options, stopChan)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results are drained even after a failed send so no worker is left blocked
+	var sendErr error
+	stopped := false
+	reachable, unreachable, failed := 0, 0, 0
+	for res := range results {
+		select {
+		case <-stopChan:
+			stopped = true
+		default:
+		}
+		if stopped || sendErr != nil {
+			continue
+		}
+
+		if res["error"] != nil {
+			failed++
+		} else if res["received"].(int) > 0 {
+			reachable++
+		} else {
+			unreachable++
+		}
+		res["taskType"] = ph.GetTaskType()
+		res["taskId"] = taskId
+		sendErr = responseSender.SendMessage("agent-response", res)
+	}
+	if stopped {
+		return fmt.Errorf("task %v received stop signal", taskId)
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+
+	res := map[string]interface{}{
+		"summary": map[string]interface{}{
+			"targets":     len(targets),
+			"reachable":   reachable,
+			"unreachable": unreachable,
+			"failed":      failed,
+		},
+		"taskType": ph.GetTaskType(),
+		"taskId":   taskId,
+	}
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// GetTaskType returns the task type identifier
+func (ph *PingBatchHandler) GetTaskType() string {
+	return "pingbatch"
+}
+
+// pingBatchTarget resolves and pings a single target, the result is keyed by the target as requested.
+// A closed stopChan ends the target between probes.
+func pingBatchTarget(target string, protocol string, options PingOptions, stopChan <-chan struct{}) map[string]interface{} {
+	res := map[string]interface{}{
+		"target": target,
+	}
+
+	host := target
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = strings.Trim(host, "[]")
+	}
+	ip, _, port, ipVersion, err := network.FilterIP(host)
+	if err != nil {
+		res["error"] = fmt.Sprintf("filterIP error: %v", err)
+		return res
+	}
+	if protocol != "tcp" {
+		port = ""
+	}
+	res["ip"] = ip
+	res["port"] = port
+	res["ipVersion"] = ipVersion
+
+	var stats *PingStats
+	if protocol == "icmp" {
+		stats, err = IcmpPing(ip, options, stopChan)
+	} else {
+		stats, err = TcpPing(map[string]interface{}{"ip": ip, "port": port}, options, stopChan)
+	}
+	if err != nil {
+		res["error"] = err.Error()
+		return res
+	}
+	for k, v := range stats.toMap() {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,286 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+)
+
+const (
+	pmtuDefaultMaxMTU  = 1500
+	pmtuDefaultTimeout = 1000
+	pmtuDefaultRetries = 2
+	pmtuDefaultPort    = "443"
+)
+
+// Path MTU discovery methods
+const (
+	pmtuMethodAuto = "auto"
+	pmtuMethodICMP = "icmp"
+	pmtuMethodTCP  = "tcp"
+)
+
+// PMTUHandler handles path MTU discovery tasks
+type PMTUHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (ph *PMTUHandler) ValidateData(data map[string]interface{}) error {
+	if data["host"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	switch method := getStringParam(data, "method", pmtuMethodAuto); method {
+	case pmtuMethodAuto, pmtuMethodICMP, pmtuMethodTCP:
+	default:
+		return fmt.Errorf("unsupported method: %s", method)
+	}
+	if err := checkIntParam(data, "maxMtu", 1280, 9216); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "timeout", 100, 5000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "retries", 1, 5); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v PMTU %v\n", clientIP, data["host"])
+	}
+	return nil
+}
+
+// PreProcess resolves the target, the port is only used by the TCP method
+func (ph *PMTUHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	// Clean host string and remove brackets for IPv6
+	host := strings.Trim(data["host"].(string), " \n\"'")
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = strings.Trim(host, "[]")
+	}
+
+	ip, hostname, port, ipVersion, err := network.FilterIP(host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterIP error: %v", err)
+	}
+
+	// FilterIP falls back to port 80, the TCP fallback defaults to 443
+	if _, _, splitErr := net.SplitHostPort(host); splitErr != nil && !strings.Contains(host, "://") {
+		port = pmtuDefaultPort
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["ip"] = ip
+	processedData["host"] = hostname
+	processedData["port"] = port
+	processedData["ipVersion"] = ipVersion
+
+	response := map[string]interface{}{
+		"ip":        ip,
+		"ipVersion": ipVersion,
+		"taskType":  ph.GetTaskType(),
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute discovers the path MTU over ICMP and falls back to the TCP MSS
+func (ph *PMTUHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	ip := data["ip"].(string)
+	port := data["port"].(string)
+	method := getStringParam(data, "method", pmtuMethodAuto)
+	maxMTU := getIntParam(data, "maxMtu", pmtuDefaultMaxMTU)
+	timeout := time.Duration(getIntParam(data, "timeout", pmtuDefaultTimeout)) * time.Millisecond
+	retries := getIntParam(data, "retries", pmtuDefaultRetries)
+
+	var result map[string]interface{}
+	var err error
+	if method != pmtuMethodTCP {
+		result, err = discoverICMPPathMTU(ip, maxMTU, timeout, retries, stopChan)
+		if err != nil && method == pmtuMethodAuto {
+			select {
+			case <-stopChan:
+				return fmt.Errorf("task %v received stop signal", taskId)
+			default:
+			}
+			log.Debugf("ICMP path MTU discovery to %s failed, using TCP MSS: %v", ip, err)
+			icmpErr := err
+			if result, err = discoverTCPPathMTU(ip, port, timeout); err == nil {
+				result["fallbackReason"] = icmpErr.Error()
+			}
+		}
+	} else {
+		result, err = discoverTCPPathMTU(ip, port, timeout)
+	}
+
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+	if err != nil {
+		errorRes := map[string]interface{}{
+			"error":    err.Error(),
+			"taskType": ph.GetTaskType(),
+			"taskId":   taskId,
+		}
+		return responseSender.SendMessage("agent-response", errorRes)
+	}
+
+	result["taskType"] = ph.GetTaskType()
+	result["taskId"] = taskId
+	return responseSender.SendMessage("agent-response", result)
+}
+
+// GetTaskType returns the task type identifier
+func (ph *PMTUHandler) GetTaskType() string {
+	return "pmtu"
+}
+
+// discoverICMPPathMTU binary searches the largest echo request that crosses the path unfragmented
+func discoverICMPPathMTU(ip string, maxMTU int, timeout time.Duration, retries int, stopChan <-chan struct{}) (map[string]interface{}, error) {
+	// Packet too big errors are only delivered to raw sockets
+	if network.GetICMPMode() != network.ICMPModePrivileged {
+		return nil, fmt.Errorf("icmp path MTU discovery requires raw socket privileges (CAP_NET_RAW)")
+	}
+
+	pinger, err := newIcmpPinger(ip, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer pinger.Close()
+	if err := pinger.SetDontFragment(); err != nil {
+		return nil, fmt.Errorf("set don't fragment error: %v", err)
+	}
+
+	// IP and ICMP header bytes on top of the echo payload
+	overhead, minMTU := 28, 68
+	if pinger.ipv6 {
+		overhead, minMTU = 48, 1280
+	}
+
+	sent := 0
+	blackHole, localLimit := false, false
+	var limitingHop string
+	var hopMTU int
+
+	// fits reports whether a packet of mtu bytes reaches the target, hint is the MTU a router reported
+	fits := func(mtu int) (bool, int, error) {
+		pinger.SetSize(mtu - overhead)
+		for i := 0; i < retries; i++ {
+			select {
+			case <-stopChan:
+				return false, 0, fmt.Errorf("received stop signal")
+			default:
+			}
+			sent++
+			probe := pinger.Probe(timeout)
+			switch probe.Status {
+			case probeStatusOK:
+				return true, 0, nil
+			case probeStatusTooBig:
+				// Without a router error the local interface or cached path MTU refused the send
+				if pinger.tooBigFrom != nil {
+					limitingHop, hopMTU = pinger.tooBigFrom.String(), pinger.tooBigMTU
+				} else {
+					localLimit = true
+				}
+				return false, pinger.tooBigMTU, nil
+			case probeStatusUnreachable:
+				return false, 0, fmt.Errorf("target unreachable")
+			}
+		}
+		// Silently dropped large packets point to a path MTU black hole
+		blackHole = true
+		return false, 0, nil
+	}
+
+	ok, _, err := fits(minMTU)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("target does not answer ICMP echo")
+	}
+
+	// Most paths carry the full size, so try it before searching
+	lo, hi := minMTU, maxMTU
+	if ok, _, err = fits(maxMTU); err != nil {
+		return nil, err
+	} else if ok {
+		lo = maxMTU
+	} else {
+		hi = maxMTU - 1
+	}
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		ok, hint, err := fits(mid)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			lo = mid
+			continue
+		}
+		hi = mid - 1
+		// Jump straight to the MTU a router reported below the probe size
+		if hint > lo && hint < hi {
+			hi = hint
+		}
+	}
+
+	// Only report the hop when it actually limits the discovered size
+	if lo == maxMTU {
+		limitingHop, hopMTU, localLimit = "", 0, false
+	}
+
+	return map[string]interface{}{
+		"method":      pmtuMethodICMP,
+		"mtu":         lo,
+		"limitingHop": limitingHop,
+		"hopMtu":      hopMTU,
+		"localLimit":  localLimit && limitingHop == "",
+		"blackHole":   blackHole && lo < maxMTU,
+		"probes":      sent,
+	}, nil
+}
+
+// discoverTCPPathMTU derives the path MTU from the MSS of a TCP connection to ip and port
+func discoverTCPPathMTU(ip, port string, timeout time.Duration) (map[string]interface{}, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, port), timeout)
+	if err != nil {
+		return nil, fmt.Errorf("connect error: %v", err)
+	}
+	defer conn.Close()
+
+	info, err := network.GetTCPInfo(conn.(*net.TCPConn))
+	if err != nil {
+		return nil, err
+	}
+
+	// The send MSS excludes the IP and TCP headers and the timestamp option
+	headers := 40
+	if net.ParseIP(ip).To4() == nil {
+		headers = 60
+	}
+	if info.Timestamps {
+		headers += 12
+	}
+
+	return map[string]interface{}{
+		"method":   pmtuMethodTCP,
+		"mtu":      info.SndMSS + headers,
+		"mss":      info.SndMSS,
+		"localMtu": info.PMTU,
+		"advMss":   info.AdvMSS,
+	}, nil
+}
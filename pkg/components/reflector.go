@@ -0,0 +1,138 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	twampMaxSessions = 1024
+	twampSessionIdle = 60 * time.Second
+	twampPurgePeriod = 10 * time.Second
+	twampUnknownTTL  = 0
+	twampReadBufSize = 65536
+)
+
+// TWAMPReflector reflects TWAMP-light test packets (RFC 5357 section 4.2) in unauthenticated mode.
+// Each sender address is a session with its own reflector sequence numbers.
+type TWAMPReflector struct {
+	mu        sync.Mutex
+	sessions  map[string]*twampReflectorSession
+	lastPurge time.Time
+}
+
+// twampReflectorSession numbers the packets reflected for one sender
+type twampReflectorSession struct {
+	seq      uint32
+	lastSeen time.Time
+}
+
+// ListenAndServe reflects test packets arriving on addr over IPv4 and, when available, IPv6
+func (tr *TWAMPReflector) ListenAndServe(addr string) error {
+	tr.sessions = make(map[string]*twampReflectorSession)
+
+	conn4, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return err
+	}
+	defer conn4.Close()
+	p4 := ipv4.NewPacketConn(conn4)
+	// The TTL of received packets is reported back where the platform exposes it
+	p4.SetControlMessage(ipv4.FlagTTL, true)
+	p4.SetTTL(twampMaxTTL)
+
+	if conn6, err := net.ListenPacket("udp6", addr); err == nil {
+		defer conn6.Close()
+		p6 := ipv6.NewPacketConn(conn6)
+		p6.SetControlMessage(ipv6.FlagHopLimit, true)
+		p6.SetHopLimit(twampMaxTTL)
+		go tr.serve(func(buf []byte) (int, int, net.Addr, error) {
+			n, cm, src, err := p6.ReadFrom(buf)
+			if cm == nil {
+				return n, twampUnknownTTL, src, err
+			}
+			return n, cm.HopLimit, src, err
+		}, func(b []byte, dst net.Addr) error {
+			_, err := p6.WriteTo(b, nil, dst)
+			return err
+		})
+	} else {
+		log.Debugf("TWAMP reflector IPv6 listen error: %v", err)
+	}
+
+	return tr.serve(func(buf []byte) (int, int, net.Addr, error) {
+		n, cm, src, err := p4.ReadFrom(buf)
+		if cm == nil {
+			return n, twampUnknownTTL, src, err
+		}
+		return n, cm.TTL, src, err
+	}, func(b []byte, dst net.Addr) error {
+		_, err := p4.WriteTo(b, nil, dst)
+		return err
+	})
+}
+
+// serve reflects packets until the socket fails, read returns the length, received TTL and source
+func (tr *TWAMPReflector) serve(read func([]byte) (int, int, net.Addr, error), write func([]byte, net.Addr) error) error {
+	buf := make([]byte, twampReadBufSize)
+	reply := make([]byte, twampReadBufSize)
+	for {
+		n, ttl, src, err := read(buf)
+		received := time.Now()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		// Replies are never larger than requests so the reflector cannot amplify traffic
+		if n < twampReflectorSize {
+			continue
+		}
+		seq, ok := tr.nextSeq(src.String(), received)
+		if !ok {
+			continue
+		}
+		putTWAMPReflectorPacket(reply[:n], buf[:n], seq, received, time.Now(), ttl)
+		// Padding is not reflected
+		clear(reply[twampReflectorSize:n])
+		if err := write(reply[:n], src); err != nil {
+			log.Debugf("TWAMP reflector send error: %v", err)
+		}
+	}
+}
+
+// nextSeq returns the next reflector sequence number for a sender, false when the session table is full
+func (tr *TWAMPReflector) nextSeq(sender string, now time.Time) (uint32, bool) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if now.Sub(tr.lastPurge) > twampPurgePeriod {
+		for key, session := range tr.sessions {
+			if now.Sub(session.lastSeen) > twampSessionIdle {
+				delete(tr.sessions, key)
+			}
+		}
+		tr.lastPurge = now
+	}
+
+	session := tr.sessions[sender]
+	if session == nil {
+		if len(tr.sessions) >= twampMaxSessions {
+			return 0, false
+		}
+		session = &twampReflectorSession{}
+		tr.sessions[sender] = session
+	} else {
+		session.seq++
+	}
+	session.lastSeen = now
+	return session.seq, true
+}
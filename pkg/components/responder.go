@@ -0,0 +1,305 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+)
+
+// ThroughputResponder serves throughput tests to other agents on a TCP and a UDP port with the same number.
+// One test runs at a time and only for agents that know Key, which must not be empty.
+type ThroughputResponder struct {
+	Key     string
+	udp     net.PacketConn
+	mu      sync.Mutex
+	session *throughputSession
+}
+
+// throughputSession is the state of the running test
+type throughputSession struct {
+	request  throughputRequest
+	cookie   []byte
+	peer     net.IP
+	data     chan *throughputDataConn
+	udpPeer  chan net.Addr
+	mu       sync.Mutex
+	receiver throughputReceiver
+}
+
+// throughputDataConn is a data connection with the bytes already buffered while reading its cookie
+type throughputDataConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// ListenAndServe listens on addr for TCP and UDP and serves tests until the TCP listener fails
+func (tr *ThroughputResponder) ListenAndServe(addr string) error {
+	// Without a key anyone could make the agent send or sink traffic
+	if tr.Key == "" {
+		return fmt.Errorf("a key is required")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	tr.udp, err = net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer tr.udp.Close()
+	go tr.serveUDP()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go tr.handle(conn)
+	}
+}
+
+// handle tells control connections from data connections by their first line
+func (tr *ThroughputResponder) handle(conn net.Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warnf("panic in throughput responder: %v", r)
+			conn.Close()
+		}
+	}()
+
+	if err := conn.SetReadDeadline(time.Now().Add(throughputSetupTimeout)); err != nil {
+		conn.Close()
+		return
+	}
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if bytes.HasPrefix(line, []byte("{")) {
+		defer conn.Close()
+		tr.serveControl(conn, reader, line)
+		return
+	}
+
+	// A data connection is handed to the session that owns its cookie
+	tr.mu.Lock()
+	s := tr.session
+	tr.mu.Unlock()
+	if s == nil || !s.owns(conn.RemoteAddr(), bytes.TrimSpace(line)) {
+		conn.Close()
+		return
+	}
+	select {
+	case s.data <- &throughputDataConn{conn: conn, reader: reader}:
+	default:
+		conn.Close()
+	}
+}
+
+// owns reports whether a data stream with the hex cookie from addr belongs to the session
+func (s *throughputSession) owns(addr net.Addr, cookie []byte) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil || !net.ParseIP(host).Equal(s.peer) {
+		return false
+	}
+	return subtle.ConstantTimeCompare(cookie, []byte(hex.EncodeToString(s.cookie))) == 1
+}
+
+// serveControl negotiates a test, runs it and sends the report
+func (tr *ThroughputResponder) serveControl(conn net.Conn, reader *bufio.Reader, line []byte) {
+	var request throughputRequest
+	if err := json.Unmarshal(line, &request); err != nil {
+		writeJSONLine(conn, throughputReply{Error: "invalid request"})
+		return
+	}
+	if err := tr.checkRequest(request); err != nil {
+		writeJSONLine(conn, throughputReply{Error: err.Error()})
+		return
+	}
+
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	s := &throughputSession{
+		request: request,
+		cookie:  make([]byte, throughputCookieSize),
+		peer:    net.ParseIP(host),
+		data:    make(chan *throughputDataConn, 1),
+		udpPeer: make(chan net.Addr, 1),
+	}
+	rand.Read(s.cookie)
+
+	tr.mu.Lock()
+	if tr.session != nil {
+		tr.mu.Unlock()
+		writeJSONLine(conn, throughputReply{Error: "responder busy"})
+		return
+	}
+	tr.session = s
+	tr.mu.Unlock()
+	defer func() {
+		tr.mu.Lock()
+		tr.session = nil
+		tr.mu.Unlock()
+	}()
+
+	if err := writeJSONLine(conn, throughputReply{Cookie: hex.EncodeToString(s.cookie)}); err != nil {
+		return
+	}
+	log.Infof("Throughput %s %s test from %s\n", request.Protocol, request.Direction, host)
+
+	duration := time.Duration(request.Duration) * time.Second
+	if err := conn.SetDeadline(time.Now().Add(duration + 2*throughputSetupTimeout)); err != nil {
+		return
+	}
+	report, err := tr.run(s, reader)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	writeJSONLine(conn, report)
+}
+
+// checkRequest rejects requests the responder does not serve
+func (tr *ThroughputResponder) checkRequest(request throughputRequest) error {
+	if request.Version != throughputProtocolVersion {
+		return fmt.Errorf("unsupported version: %d", request.Version)
+	}
+	if subtle.ConstantTimeCompare([]byte(request.Key), []byte(tr.Key)) != 1 {
+		return fmt.Errorf("invalid key")
+	}
+	if request.Direction != throughputUpload && request.Direction != throughputDownload {
+		return fmt.Errorf("unsupported direction: %s", request.Direction)
+	}
+	if request.Duration < 1 || request.Duration > throughputMaxDuration {
+		return fmt.Errorf("duration must be between 1 and %d seconds", throughputMaxDuration)
+	}
+	switch request.Protocol {
+	case "tcp":
+	case "udp":
+		if request.Bitrate < 1 || request.Bitrate > throughputMaxBitrate*1000000 {
+			return fmt.Errorf("bitrate must be between 1 and %d Mbps", throughputMaxBitrate)
+		}
+		if request.PacketSize < throughputMinPacketSize || request.PacketSize > throughputMaxPacketSize {
+			return fmt.Errorf("packet size must be between %d and %d", throughputMinPacketSize, throughputMaxPacketSize)
+		}
+	default:
+		return fmt.Errorf("unsupported protocol: %s", request.Protocol)
+	}
+	return nil
+}
+
+// run performs the responder side of the data phase, the directions are those of the client
+func (tr *ThroughputResponder) run(s *throughputSession, control *bufio.Reader) (throughputReport, error) {
+	var report throughputReport
+	request := s.request
+	duration := time.Duration(request.Duration) * time.Second
+
+	if request.Protocol == "tcp" {
+		var data *throughputDataConn
+		select {
+		case data = <-s.data:
+		case <-time.After(throughputSetupTimeout):
+			return report, fmt.Errorf("data connection not established")
+		}
+		defer data.conn.Close()
+
+		if request.Direction == throughputUpload {
+			recv := &throughputReceiver{}
+			err := receiveThroughputTCP(data.reader, data.conn, time.Now().Add(duration+throughputSetupTimeout), recv)
+			report.Bytes, report.Intervals = recv.bytes, recv.intervals
+			return report, err
+		}
+		sent, err := sendThroughputTCP(data.conn, duration)
+		report.Bytes = sent
+		if info, infoErr := network.GetTCPInfo(data.conn.(*net.TCPConn)); infoErr == nil {
+			report.Retransmits = &info.TotalRetrans
+			report.RTT = roundToDecimal(float64(info.RTT.Microseconds())/1000.0, 3)
+		}
+		return report, err
+	}
+
+	if request.Direction == throughputUpload {
+		// The client reports what it sent once it is done, late datagrams are still counted for a moment
+		var sent throughputReport
+		if err := readJSONLine(control, &sent); err != nil {
+			return report, fmt.Errorf("receive error: %v", err)
+		}
+		time.Sleep(throughputDrainTime)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		report.Bytes, report.Intervals = s.receiver.bytes, s.receiver.intervals
+		report.Packets, report.OutOfOrder = s.receiver.packets, s.receiver.outOfOrder
+		report.Jitter = s.receiver.jitterMs()
+		return report, nil
+	}
+
+	var peer net.Addr
+	select {
+	case peer = <-s.udpPeer:
+	case <-time.After(throughputSetupTimeout):
+		return report, fmt.Errorf("no UDP hello received")
+	}
+	write := func(packet []byte) error {
+		_, err := tr.udp.WriteTo(packet, peer)
+		return err
+	}
+	packets, sentBytes, err := sendThroughputUDP(write, s.cookie, request.Bitrate, request.PacketSize, duration)
+	report.Packets, report.Bytes = packets, sentBytes
+	return report, err
+}
+
+// serveUDP dispatches datagrams to the running test by cookie
+func (tr *ThroughputResponder) serveUDP() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := tr.udp.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		now := time.Now()
+		cookie, seq, sent, ok := parseThroughputHeader(buf[:n])
+		if !ok {
+			continue
+		}
+
+		tr.mu.Lock()
+		s := tr.session
+		tr.mu.Unlock()
+		if s == nil || s.request.Protocol != "udp" || !bytes.Equal(cookie, s.cookie) {
+			continue
+		}
+
+		if s.request.Direction == throughputUpload {
+			if seq > 0 {
+				s.mu.Lock()
+				s.receiver.addPacket(seq, sent, n, now)
+				s.mu.Unlock()
+			}
+			continue
+		}
+		// Only stream to the host that opened the test, a leaked cookie must not redirect the flood
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if seq == 0 && ok && udpAddr.IP.Equal(s.peer) {
+			select {
+			case s.udpPeer <- addr:
+			default:
+			}
+		}
+	}
+}
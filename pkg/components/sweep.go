@@ -0,0 +1,293 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"github.com/spf13/viper"
+)
+
+const (
+	sweepDefaultRate      = 100
+	sweepMaxRate          = 1000
+	sweepDefaultBatchSize = 32
+	sweepMaxBatchSize     = 256
+	sweepDefaultPort      = "80"
+	// sweepMaxInFlight caps concurrent probes however fast the rate is
+	sweepMaxInFlight = 256
+	// sweepMinPrefix keeps the configured limit from allowing more than 65536 addresses, smaller values are rejected
+	sweepMinPrefix     = 16
+	sweepFlushInterval = time.Second
+)
+
+// SweepHandler handles host discovery tasks that probe every address of a network
+type SweepHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (sh *SweepHandler) ValidateData(data map[string]interface{}) error {
+	if data["host"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if protocol := getStringParam(data, "protocol", "icmp"); protocol != "icmp" && protocol != "tcp" {
+		return fmt.Errorf("unsupported protocol: %v", data["protocol"])
+	}
+	if err := checkIntParam(data, "port", 1, 65535); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "timeout", 100, 5000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "rate", 1, sweepMaxRate); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "batchSize", 1, sweepMaxBatchSize); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v Sweep %v %v\n", clientIP, data["host"], data["protocol"])
+	}
+	return nil
+}
+
+// PreProcess parses the network and checks it against the configured maximum size
+func (sh *SweepHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	prefix, ipVersion, err := network.FilterCIDR(data["host"].(string))
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterCIDR error: %v", err)
+	}
+
+	// The limit is an IPv4 prefix length, IPv6 networks may have as many host bits
+	maxPrefix, err := SweepMaxPrefix()
+	if err != nil {
+		return nil, nil, err
+	}
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 32-maxPrefix {
+		return nil, nil, fmt.Errorf("network %s exceeds the maximum size of /%d (%d addresses)", prefix, prefix.Addr().BitLen()-32+maxPrefix, 1<<(32-maxPrefix))
+	}
+
+	protocol := getStringParam(data, "protocol", "icmp")
+	port := ""
+	if protocol == "tcp" {
+		port = sweepDefaultPort
+		if data["port"] != nil {
+			port = strconv.Itoa(getIntParam(data, "port", 0))
+		}
+	}
+	addrs := sweepAddrs(prefix)
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["prefix"] = prefix
+	processedData["protocol"] = protocol
+	processedData["port"] = port
+
+	response := map[string]interface{}{
+		"network":   prefix.String(),
+		"ipVersion": ipVersion,
+		"protocol":  protocol,
+		"port":      port,
+		"total":     len(addrs),
+		"taskType":  sh.GetTaskType(),
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute probes the addresses at the requested rate and streams the live ones in batches
+func (sh *SweepHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	prefix := data["prefix"].(netip.Prefix)
+	protocol := data["protocol"].(string)
+	port := data["port"].(string)
+	timeout := time.Duration(getIntParam(data, "timeout", pingDefaultTimeout)) * time.Millisecond
+	rate := getIntParam(data, "rate", sweepDefaultRate)
+	batchSize := getIntParam(data, "batchSize", sweepDefaultBatchSize)
+	addrs := sweepAddrs(prefix)
+	startTime := time.Now()
+
+	// Probes start one per tick and at most sweepMaxInFlight run at once
+	results := make(chan sweepResult)
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(results)
+		}()
+		ticker := time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+		slots := make(chan struct{}, sweepMaxInFlight)
+		for _, addr := range addrs {
+			select {
+			case <-stopChan:
+				return
+			case <-ticker.C:
+			}
+			select {
+			case <-stopChan:
+				return
+			case slots <- struct{}{}:
+			}
+			wg.Add(1)
+			go func(ip string) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				host, err := sweepProbe(ip, protocol, port, timeout)
+				results <- sweepResult{host: host, err: err}
+			}(addr.String())
+		}
+	}()
+
+	flush := time.NewTicker(sweepFlushInterval)
+	defer flush.Stop()
+	batch := make([]map[string]interface{}, 0, batchSize)
+	scanned, alive, failed := 0, 0, 0
+	var probeErr, sendErr error
+	send := func() {
+		if len(batch) == 0 || sendErr != nil {
+			return
+		}
+		select {
+		case <-stopChan:
+			return
+		default:
+		}
+		res := map[string]interface{}{
+			"hosts":    batch,
+			"scanned":  scanned,
+			"total":    len(addrs),
+			"taskType": sh.GetTaskType(),
+			"taskId":   taskId,
+		}
+		sendErr = responseSender.SendMessage("agent-response", res)
+		batch = make([]map[string]interface{}, 0, batchSize)
+	}
+
+	// Results are drained until every probe is done so none is left blocked
+	for done := false; !done; {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				done = true
+				break
+			}
+			scanned++
+			if res.err != nil {
+				failed++
+				probeErr = res.err
+			} else if res.host != nil {
+				alive++
+				batch = append(batch, res.host)
+				if len(batch) >= batchSize {
+					send()
+				}
+			}
+		case <-flush.C:
+			send()
+		}
+	}
+
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+	send()
+	if sendErr != nil {
+		return sendErr
+	}
+
+	summary := map[string]interface{}{
+		"network": prefix.String(),
+		"total":   len(addrs),
+		"scanned": scanned,
+		"alive":   alive,
+		"failed":  failed,
+		"time":    roundToDecimal(time.Since(startTime).Seconds(), 3),
+	}
+	// Probes that could not be sent, such as without ICMP permission, must not read as dead hosts
+	if probeErr != nil {
+		summary["error"] = probeErr.Error()
+	}
+	res := map[string]interface{}{
+		"summary":  summary,
+		"taskType": sh.GetTaskType(),
+		"taskId":   taskId,
+	}
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// GetTaskType returns the task type identifier
+func (sh *SweepHandler) GetTaskType() string {
+	return "sweep"
+}
+
+// SweepMaxPrefix returns the sweep.maxprefix limit, an error when it is not between sweepMinPrefix and 32
+func SweepMaxPrefix() (int, error) {
+	maxPrefix := viper.GetInt("sweep.maxprefix")
+	if maxPrefix < sweepMinPrefix || maxPrefix > 32 {
+		return 0, fmt.Errorf("sweep.maxprefix must be between %d and 32, got %q", sweepMinPrefix, viper.GetString("sweep.maxprefix"))
+	}
+	return maxPrefix, nil
+}
+
+// sweepResult is the outcome of probing one address, host is nil when it did not answer
+type sweepResult struct {
+	host map[string]interface{}
+	err  error
+}
+
+// sweepAddrs lists the addresses of prefix, leaving out the IPv4 network and broadcast addresses
+func sweepAddrs(prefix netip.Prefix) []netip.Addr {
+	var addrs []netip.Addr
+	for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+		addrs = append(addrs, addr)
+	}
+	if prefix.Addr().Is4() && prefix.Bits() < 31 {
+		addrs = addrs[1 : len(addrs)-1]
+	}
+	return addrs
+}
+
+// sweepProbe reports an address that answers, nil otherwise.
+// A refused TCP connection still proves the host is up.
+func sweepProbe(ip string, protocol string, port string, timeout time.Duration) (map[string]interface{}, error) {
+	var probe PingProbe
+	if protocol == "tcp" {
+		startTime := time.Now()
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, port), timeout)
+		probe.Status = tcpProbeStatus(err)
+		if err == nil {
+			conn.Close()
+		}
+		if err == nil || probe.Status == probeStatusRefused {
+			probe.RTT = roundToDecimal(float64(time.Since(startTime).Microseconds())/1000.0, 3)
+		}
+	} else {
+		stats, err := IcmpPing(ip, PingOptions{Count: 1, Timeout: timeout, Size: pingDefaultSize}, nil)
+		if err != nil {
+			return nil, err
+		}
+		probe = stats.Probes[0]
+	}
+
+	if probe.Status != probeStatusOK && probe.Status != probeStatusRefused {
+		return nil, nil
+	}
+	return map[string]interface{}{
+		"ip":     ip,
+		"status": probe.Status,
+		"rtt":    probe.RTT,
+	}, nil
+}
@@ -0,0 +1,639 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+)
+
+const (
+	throughputDefaultPort       = "5201"
+	throughputDefaultDuration   = 10
+	throughputMaxDuration       = 30
+	throughputDefaultBitrate    = 100
+	throughputMaxBitrate        = 1000
+	throughputDefaultPacketSize = 1200
+	throughputMinPacketSize     = 64
+	throughputMaxPacketSize     = 1472
+	throughputProtocolVersion   = 1
+	throughputCookieSize        = 16
+	throughputHeaderSize        = 32
+	throughputBufferSize        = 128 * 1024
+	throughputSetupTimeout      = 5 * time.Second
+	throughputDrainTime         = 500 * time.Millisecond
+	throughputHelloInterval     = 200 * time.Millisecond
+)
+
+// Throughput test directions, seen from the agent running the task
+const (
+	throughputUpload   = "upload"
+	throughputDownload = "download"
+)
+
+// ThroughputHandler handles throughput tests against the responder of another agent
+type ThroughputHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (th *ThroughputHandler) ValidateData(data map[string]interface{}) error {
+	if data["host"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	switch protocol := getStringParam(data, "protocol", "tcp"); protocol {
+	case "tcp", "udp":
+	default:
+		return fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+	switch direction := getStringParam(data, "direction", throughputDownload); direction {
+	case throughputUpload, throughputDownload:
+	default:
+		return fmt.Errorf("unsupported direction: %s", direction)
+	}
+	if err := checkIntParam(data, "duration", 1, throughputMaxDuration); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "bitrate", 1, throughputMaxBitrate); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "packetSize", throughputMinPacketSize, throughputMaxPacketSize); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v Throughput %v\n", clientIP, data["host"])
+	}
+	return nil
+}
+
+// PreProcess resolves the responder and applies its default port
+func (th *ThroughputHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	// Clean host string and remove brackets for IPv6
+	host := strings.Trim(data["host"].(string), " \n\"'")
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = strings.Trim(host, "[]")
+	}
+
+	ip, hostname, port, ipVersion, err := network.FilterIP(host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterIP error: %v", err)
+	}
+
+	// FilterIP falls back to port 80, responders listen on 5201 unless configured otherwise
+	if _, _, splitErr := net.SplitHostPort(host); splitErr != nil {
+		port = throughputDefaultPort
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["ip"] = ip
+	processedData["host"] = hostname
+	processedData["port"] = port
+	processedData["ipVersion"] = ipVersion
+
+	response := map[string]interface{}{
+		"ip":        ip,
+		"port":      port,
+		"ipVersion": ipVersion,
+		"taskType":  th.GetTaskType(),
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute runs the test and reports throughput over time
+func (th *ThroughputHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	addr := net.JoinHostPort(data["ip"].(string), data["port"].(string))
+	options := ThroughputOptions{
+		Protocol:   getStringParam(data, "protocol", "tcp"),
+		Direction:  getStringParam(data, "direction", throughputDownload),
+		Duration:   time.Duration(getIntParam(data, "duration", throughputDefaultDuration)) * time.Second,
+		Bitrate:    int64(getIntParam(data, "bitrate", throughputDefaultBitrate)) * 1000000,
+		PacketSize: getIntParam(data, "packetSize", throughputDefaultPacketSize),
+		Key:        getStringParam(data, "key", ""),
+	}
+	result, err := runThroughputTest(addr, options, stopChan)
+
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+	if err != nil {
+		errorRes := map[string]interface{}{
+			"error":    err.Error(),
+			"taskType": th.GetTaskType(),
+			"taskId":   taskId,
+		}
+		return responseSender.SendMessage("agent-response", errorRes)
+	}
+
+	res := result.toMap()
+	res["taskType"] = th.GetTaskType()
+	res["taskId"] = taskId
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// GetTaskType returns the task type identifier
+func (th *ThroughputHandler) GetTaskType() string {
+	return "throughput"
+}
+
+// ThroughputOptions configures a throughput test, the bitrate in bits per second only applies to UDP
+type ThroughputOptions struct {
+	Protocol   string
+	Direction  string
+	Duration   time.Duration
+	Bitrate    int64
+	PacketSize int
+	Key        string
+}
+
+// ThroughputInterval is the throughput seen by the receiver during one second of the test
+type ThroughputInterval struct {
+	Start int     `json:"start"`
+	End   int     `json:"end"`
+	Bytes int64   `json:"bytes"`
+	Mbps  float64 `json:"mbps"`
+}
+
+// ThroughputResult summarizes a throughput test, retransmits and RTT come from the TCP sender
+type ThroughputResult struct {
+	Protocol    string
+	Direction   string
+	Duration    float64
+	Bytes       int64
+	Mbps        float64
+	Intervals   []ThroughputInterval
+	Retransmits *int
+	RTT         float64
+	Sent        uint64
+	Received    uint64
+	Loss        float64
+	OutOfOrder  uint64
+	Jitter      float64
+}
+
+// toMap converts the result into the response format
+func (tr *ThroughputResult) toMap() map[string]interface{} {
+	res := map[string]interface{}{
+		"protocol":  tr.Protocol,
+		"direction": tr.Direction,
+		"duration":  tr.Duration,
+		"bytes":     tr.Bytes,
+		"mbps":      tr.Mbps,
+		"intervals": tr.Intervals,
+	}
+	if tr.Protocol == "udp" {
+		res["sent"] = tr.Sent
+		res["received"] = tr.Received
+		res["loss"] = tr.Loss
+		res["outOfOrder"] = tr.OutOfOrder
+		res["jitter"] = tr.Jitter
+	} else if tr.Retransmits != nil {
+		res["retransmits"] = *tr.Retransmits
+		res["rtt"] = tr.RTT
+	}
+	return res
+}
+
+// throughputRequest opens a test on the control connection
+type throughputRequest struct {
+	Version    int    `json:"version"`
+	Key        string `json:"key,omitempty"`
+	Protocol   string `json:"protocol"`
+	Direction  string `json:"direction"`
+	Duration   int    `json:"duration"`
+	Bitrate    int64  `json:"bitrate,omitempty"`
+	PacketSize int    `json:"packetSize,omitempty"`
+}
+
+// throughputReply accepts a request with the cookie that binds the data stream to it
+type throughputReply struct {
+	Cookie string `json:"cookie,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// throughputReport is sent over the control connection once the data phase ends.
+// The client reports the packets it sent in a UDP upload, the responder its side of every test.
+type throughputReport struct {
+	Bytes       int64   `json:"bytes"`
+	Packets     uint64  `json:"packets"`
+	OutOfOrder  uint64  `json:"outOfOrder,omitempty"`
+	Jitter      float64 `json:"jitter,omitempty"`
+	Intervals   []int64 `json:"intervals,omitempty"`
+	Retransmits *int    `json:"retransmits,omitempty"`
+	RTT         float64 `json:"rtt,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// writeJSONLine sends v as a single line of JSON
+func writeJSONLine(w io.Writer, v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// readJSONLine decodes the next line of JSON, lines longer than the reader buffer are rejected
+func readJSONLine(r *bufio.Reader, v interface{}) error {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
+
+// throughputReceiver accounts received bytes per second and, for UDP, reordering and jitter
+type throughputReceiver struct {
+	start      time.Time
+	bytes      int64
+	intervals  []int64
+	packets    uint64
+	maxSeq     uint64
+	outOfOrder uint64
+	jitter     float64
+	transit    time.Duration
+}
+
+// add accounts n bytes received at now, intervals start with the first byte
+func (r *throughputReceiver) add(n int, now time.Time) {
+	if r.start.IsZero() {
+		r.start = now
+	}
+	i := int(now.Sub(r.start) / time.Second)
+	for len(r.intervals) <= i {
+		r.intervals = append(r.intervals, 0)
+	}
+	r.intervals[i] += int64(n)
+	r.bytes += int64(n)
+}
+
+// addPacket accounts a datagram, jitter follows RFC 3550 and does not depend on the clock offset
+func (r *throughputReceiver) addPacket(seq uint64, sent time.Time, n int, now time.Time) {
+	r.add(n, now)
+	r.packets++
+	if seq < r.maxSeq {
+		r.outOfOrder++
+	} else {
+		r.maxSeq = seq
+	}
+	transit := now.Sub(sent)
+	if r.packets > 1 {
+		d := transit - r.transit
+		if d < 0 {
+			d = -d
+		}
+		r.jitter += (float64(d) - r.jitter) / 16
+	}
+	r.transit = transit
+}
+
+// jitterMs returns the interarrival jitter in milliseconds
+func (r *throughputReceiver) jitterMs() float64 {
+	return roundToDecimal(r.jitter/float64(time.Millisecond), 3)
+}
+
+// putThroughputHeader writes the cookie, sequence number and send time in front of a datagram
+func putThroughputHeader(packet, cookie []byte, seq uint64, now time.Time) {
+	copy(packet[0:throughputCookieSize], cookie)
+	binary.BigEndian.PutUint64(packet[16:24], seq)
+	binary.BigEndian.PutUint64(packet[24:32], uint64(now.UnixNano()))
+}
+
+// parseThroughputHeader reads the header written by putThroughputHeader
+func parseThroughputHeader(packet []byte) ([]byte, uint64, time.Time, bool) {
+	if len(packet) < throughputHeaderSize {
+		return nil, 0, time.Time{}, false
+	}
+	seq := binary.BigEndian.Uint64(packet[16:24])
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(packet[24:32])))
+	return packet[0:throughputCookieSize], seq, sent, true
+}
+
+// sendThroughputTCP writes as fast as the connection accepts until the duration elapses
+func sendThroughputTCP(conn net.Conn, duration time.Duration) (int64, error) {
+	// Random data keeps compressing middleboxes from inflating the result
+	buf := make([]byte, throughputBufferSize)
+	rand.Read(buf)
+	if err := conn.SetWriteDeadline(time.Now().Add(duration)); err != nil {
+		return 0, err
+	}
+	var sent int64
+	for {
+		n, err := conn.Write(buf)
+		sent += int64(n)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, fmt.Errorf("send error: %v", err)
+		}
+	}
+}
+
+// receiveThroughputTCP reads until the sender closes the stream or the deadline passes
+func receiveThroughputTCP(r io.Reader, conn net.Conn, deadline time.Time, recv *throughputReceiver) error {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	buf := make([]byte, throughputBufferSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			recv.add(n, time.Now())
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("receive error: %v", err)
+		}
+	}
+}
+
+// sendThroughputUDP paces datagrams of size bytes at bitrate bits per second for the duration.
+// It returns the number of datagrams and bytes handed to write.
+func sendThroughputUDP(write func([]byte) error, cookie []byte, bitrate int64, size int, duration time.Duration) (uint64, int64, error) {
+	packet := make([]byte, size)
+	rand.Read(packet[throughputHeaderSize:])
+	interval := time.Duration(float64(size*8) / float64(bitrate) * float64(time.Second))
+	if interval <= 0 {
+		interval = 1
+	}
+
+	start := time.Now()
+	var seq uint64
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= duration {
+			break
+		}
+		// Catch up in bursts, timers are too coarse to space single datagrams at high rates
+		due := uint64(elapsed/interval) + 1
+		for seq < due {
+			seq++
+			putThroughputHeader(packet, cookie, seq, time.Now())
+			if err := write(packet); err != nil && network.IsPortUnreachable(err) {
+				return seq, int64(seq) * int64(size), fmt.Errorf("send error: %v", err)
+			}
+		}
+		if wait := time.Until(start.Add(time.Duration(seq) * interval)); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return seq, int64(seq) * int64(size), nil
+}
+
+// runThroughputTest negotiates a test with the responder at addr and runs its data phase
+func runThroughputTest(addr string, options ThroughputOptions, stopChan <-chan struct{}) (*ThroughputResult, error) {
+	control, err := net.DialTimeout("tcp", addr, throughputSetupTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connect error: %v", err)
+	}
+
+	// Closing the connections on stop aborts any blocking read or write
+	var mu sync.Mutex
+	conns := []io.Closer{control}
+	track := func(c io.Closer) {
+		mu.Lock()
+		conns = append(conns, c)
+		mu.Unlock()
+	}
+	closeAll := func() {
+		mu.Lock()
+		for _, c := range conns {
+			c.Close()
+		}
+		mu.Unlock()
+	}
+	done := make(chan struct{})
+	defer close(done)
+	defer closeAll()
+	go func() {
+		select {
+		case <-stopChan:
+			closeAll()
+		case <-done:
+		}
+	}()
+
+	request := throughputRequest{
+		Version:   throughputProtocolVersion,
+		Key:       options.Key,
+		Protocol:  options.Protocol,
+		Direction: options.Direction,
+		Duration:  int(options.Duration / time.Second),
+	}
+	if options.Protocol == "udp" {
+		request.Bitrate = options.Bitrate
+		request.PacketSize = options.PacketSize
+	}
+
+	reader := bufio.NewReader(control)
+	if err := control.SetDeadline(time.Now().Add(throughputSetupTimeout)); err != nil {
+		return nil, err
+	}
+	if err := writeJSONLine(control, request); err != nil {
+		return nil, fmt.Errorf("send error: %v", err)
+	}
+	var reply throughputReply
+	if err := readJSONLine(reader, &reply); err != nil {
+		return nil, fmt.Errorf("receive error: %v", err)
+	}
+	if reply.Error != "" {
+		return nil, fmt.Errorf("responder refused the test: %s", reply.Error)
+	}
+	cookie, err := hex.DecodeString(reply.Cookie)
+	if err != nil || len(cookie) != throughputCookieSize {
+		return nil, fmt.Errorf("responder sent an invalid cookie")
+	}
+
+	// The control connection idles during the data phase
+	if err := control.SetDeadline(time.Now().Add(options.Duration + 2*throughputSetupTimeout)); err != nil {
+		return nil, err
+	}
+
+	result := &ThroughputResult{
+		Protocol:  options.Protocol,
+		Direction: options.Direction,
+		Duration:  options.Duration.Seconds(),
+	}
+	var report throughputReport
+	var intervals []int64
+
+	if options.Protocol == "tcp" {
+		data, err := net.DialTimeout("tcp", addr, throughputSetupTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("data connection error: %v", err)
+		}
+		track(data)
+		if _, err := data.Write([]byte(reply.Cookie + "\n")); err != nil {
+			return nil, fmt.Errorf("send error: %v", err)
+		}
+
+		if options.Direction == throughputUpload {
+			if _, err := sendThroughputTCP(data, options.Duration); err != nil {
+				return nil, err
+			}
+			// Retransmits are counted by the sender, read them before the connection goes away
+			if info, infoErr := network.GetTCPInfo(data.(*net.TCPConn)); infoErr == nil {
+				result.Retransmits = &info.TotalRetrans
+				result.RTT = roundToDecimal(float64(info.RTT.Microseconds())/1000.0, 3)
+			}
+			data.(*net.TCPConn).CloseWrite()
+			if err := readJSONLine(reader, &report); err != nil {
+				return nil, fmt.Errorf("receive report error: %v", err)
+			}
+			result.Bytes, intervals = report.Bytes, report.Intervals
+		} else {
+			recv := &throughputReceiver{}
+			if err := receiveThroughputTCP(data, data, time.Now().Add(options.Duration+throughputSetupTimeout), recv); err != nil {
+				return nil, err
+			}
+			if err := readJSONLine(reader, &report); err != nil {
+				return nil, fmt.Errorf("receive report error: %v", err)
+			}
+			result.Retransmits, result.RTT = report.Retransmits, report.RTT
+			result.Bytes, intervals = recv.bytes, recv.intervals
+		}
+	} else {
+		conn, err := net.DialTimeout("udp", addr, throughputSetupTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("dial udp error: %v", err)
+		}
+		track(conn)
+
+		if options.Direction == throughputUpload {
+			write := func(packet []byte) error {
+				_, err := conn.Write(packet)
+				return err
+			}
+			sent, sentBytes, err := sendThroughputUDP(write, cookie, options.Bitrate, options.PacketSize, options.Duration)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeJSONLine(control, throughputReport{Bytes: sentBytes, Packets: sent}); err != nil {
+				return nil, fmt.Errorf("send error: %v", err)
+			}
+			if err := readJSONLine(reader, &report); err != nil {
+				return nil, fmt.Errorf("receive report error: %v", err)
+			}
+			result.Sent, result.Received = sent, report.Packets
+			result.OutOfOrder, result.Jitter = report.OutOfOrder, report.Jitter
+			result.Bytes, intervals = report.Bytes, report.Intervals
+		} else {
+			recv := &throughputReceiver{}
+			if err := receiveThroughputUDP(conn, cookie, reader, &report, recv); err != nil {
+				return nil, err
+			}
+			result.Sent, result.Received = report.Packets, recv.packets
+			result.OutOfOrder, result.Jitter = recv.outOfOrder, recv.jitterMs()
+			result.Bytes, intervals = recv.bytes, recv.intervals
+		}
+		if result.Sent > 0 && result.Received < result.Sent {
+			result.Loss = roundToDecimal(float64(result.Sent-result.Received)/float64(result.Sent)*100, 2)
+		}
+	}
+	if report.Error != "" {
+		return nil, fmt.Errorf("responder error: %s", report.Error)
+	}
+
+	result.Mbps = roundToDecimal(float64(result.Bytes)*8/options.Duration.Seconds()/1e6, 3)
+	// Bytes still in flight when the sender stops belong to its last second
+	if seconds := int(options.Duration / time.Second); len(intervals) > seconds {
+		for _, n := range intervals[seconds:] {
+			intervals[seconds-1] += n
+		}
+		intervals = intervals[:seconds]
+	}
+	result.Intervals = make([]ThroughputInterval, 0, len(intervals))
+	for i, n := range intervals {
+		result.Intervals = append(result.Intervals, ThroughputInterval{
+			Start: i,
+			End:   i + 1,
+			Bytes: n,
+			Mbps:  roundToDecimal(float64(n)*8/1e6, 3),
+		})
+	}
+	return result, nil
+}
+
+// receiveThroughputUDP says hello until the responder streams to us and receives until its report
+// arrives, plus a short drain for datagrams still in flight
+func receiveThroughputUDP(conn net.Conn, cookie []byte, control *bufio.Reader, report *throughputReport, recv *throughputReceiver) error {
+	reportChan := make(chan error, 1)
+	go func() {
+		reportChan <- readJSONLine(control, report)
+	}()
+
+	hello := make([]byte, throughputHeaderSize)
+	putThroughputHeader(hello, cookie, 0, time.Now())
+	if _, err := conn.Write(hello); err != nil {
+		return fmt.Errorf("send error: %v", err)
+	}
+
+	buf := make([]byte, 65536)
+	var drainUntil time.Time
+	for {
+		wait := throughputHelloInterval
+		if !drainUntil.IsZero() {
+			wait = time.Until(drainUntil)
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+			return err
+		}
+		n, err := conn.Read(buf)
+		now := time.Now()
+		if err == nil {
+			if packetCookie, seq, sent, ok := parseThroughputHeader(buf[:n]); ok && seq > 0 && bytes.Equal(packetCookie, cookie) {
+				recv.addPacket(seq, sent, n, now)
+			}
+		} else if udpProbeStatus(err) != probeStatusTimeout {
+			return fmt.Errorf("receive error: %v", err)
+		} else if recv.packets == 0 && drainUntil.IsZero() {
+			// The hello may have been lost
+			putThroughputHeader(hello, cookie, 0, now)
+			conn.Write(hello)
+		}
+
+		if drainUntil.IsZero() {
+			select {
+			case err := <-reportChan:
+				if err != nil {
+					return fmt.Errorf("receive report error: %v", err)
+				}
+				drainUntil = now.Add(throughputDrainTime)
+			default:
+			}
+		} else if !now.Before(drainUntil) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+)
+
+const tlsDefaultTimeout = 5000
+
+// TLSHandler handles TLS certificate and handshake inspection tasks
+type TLSHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (th *TLSHandler) ValidateData(data map[string]interface{}) error {
+	if data["host"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if err := checkIntParam(data, "timeout", 500, 30000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v TLS %v\n", clientIP, data["host"])
+	}
+	return nil
+}
+
+// PreProcess resolves the target and derives the default SNI
+func (th *TLSHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	// Clean host string and remove brackets for IPv6
+	host := strings.Trim(data["host"].(string), " \n\"'")
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = strings.Trim(host, "[]")
+	}
+
+	ip, hostname, port, ipVersion, err := network.FilterIP(host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterIP error: %v", err)
+	}
+
+	// FilterIP falls back to port 80, TLS targets default to 443
+	if _, _, splitErr := net.SplitHostPort(host); splitErr != nil && !strings.Contains(host, "://") {
+		port = "443"
+	}
+
+	// SNI defaults to the hostname, never to a literal IP
+	sni := getStringParam(data, "sni", "")
+	if sni == "" && net.ParseIP(hostname) == nil {
+		sni = hostname
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["ip"] = ip
+	processedData["host"] = hostname
+	processedData["port"] = port
+	processedData["sni"] = sni
+	processedData["ipVersion"] = ipVersion
+
+	response := map[string]interface{}{
+		"ip":        ip,
+		"port":      port,
+		"sni":       sni,
+		"ipVersion": ipVersion,
+		"taskType":  th.GetTaskType(),
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute performs the handshake and reports the negotiated parameters and certificate chain
+func (th *TLSHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	ip := data["ip"].(string)
+	port := data["port"].(string)
+	sni := data["sni"].(string)
+	alpn := getStringListParam(data, "alpn")
+	timeout := time.Duration(getIntParam(data, "timeout", tlsDefaultTimeout)) * time.Millisecond
+
+	result, err := inspectTLS(net.JoinHostPort(ip, port), sni, alpn, timeout)
+	if err != nil {
+		errorRes := map[string]interface{}{
+			"error":    err.Error(),
+			"taskType": th.GetTaskType(),
+			"taskId":   taskId,
+		}
+		return responseSender.SendMessage("agent-response", errorRes)
+	}
+
+	result["taskType"] = th.GetTaskType()
+	result["taskId"] = taskId
+	return responseSender.SendMessage("agent-response", result)
+}
+
+// GetTaskType returns the task type identifier
+func (th *TLSHandler) GetTaskType() string {
+	return "tls"
+}
+
+// inspectTLS connects to addr, completes a handshake and describes the session
+func inspectTLS(addr string, sni string, alpn []string, timeout time.Duration) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	connectStart := time.Now()
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect error: %v", err)
+	}
+	defer conn.Close()
+	connectTime := time.Since(connectStart)
+
+	// Verification is done separately so broken chains and legacy versions can still be inspected
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         sni,
+		NextProtos:         alpn,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		CipherSuites:       tlsInspectionCipherSuites(),
+	})
+	handshakeStart := time.Now()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("tls handshake error: %v", err)
+	}
+	handshakeTime := time.Since(handshakeStart)
+
+	state := tlsConn.ConnectionState()
+	host, _, _ := net.SplitHostPort(addr)
+	result := describeTLSState(state, sni, host)
+	result["connectTime"] = roundToDecimal(float64(connectTime.Microseconds())/1000.0, 3)
+	result["handshakeTime"] = roundToDecimal(float64(handshakeTime.Microseconds())/1000.0, 3)
+	return result, nil
+}
+
+// tlsInspectionCipherSuites lists every suite Go considers secure, including the RSA key exchange
+// ones it leaves out by default that servers stuck on TLS 1.0 and 1.1 often require
+func tlsInspectionCipherSuites() []uint16 {
+	suites := tls.CipherSuites()
+	ids := make([]uint16, 0, len(suites))
+	for _, suite := range suites {
+		ids = append(ids, suite.ID)
+	}
+	return ids
+}
+
+// describeTLSState summarizes a TLS session and verifies its chain against the system roots for sni, or ip without one.
+// Versions below TLS 1.2, deprecated by RFC 8996, are flagged rather than refused.
+func describeTLSState(state tls.ConnectionState, sni string, ip string) map[string]interface{} {
+	certificates := make([]map[string]interface{}, 0, len(state.PeerCertificates))
+	for _, cert := range state.PeerCertificates {
+		certificates = append(certificates, describeCertificate(cert))
+	}
+
+	result := map[string]interface{}{
+		"tlsVersion":        tls.VersionName(state.Version),
+		"deprecatedVersion": state.Version < tls.VersionTLS12,
+		"cipherSuite":       tls.CipherSuiteName(state.CipherSuite),
+		"alpn":              state.NegotiatedProtocol,
+		"ocspStapled":       len(state.OCSPResponse) > 0,
+		"certificates":      certificates,
+		"verified":          false,
+		"verifyError":       "",
+	}
+
+	if len(state.PeerCertificates) == 0 {
+		result["verifyError"] = "no peer certificate"
+		return result
+	}
+
+	leaf := state.PeerCertificates[0]
+	result["daysUntilExpiry"] = int(math.Floor(time.Until(leaf.NotAfter).Hours() / 24))
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	// Without SNI the certificate must still name the dialed IP, an empty name would skip the check
+	name := sni
+	if name == "" {
+		name = ip
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: name, Intermediates: intermediates}); err != nil {
+		result["verifyError"] = err.Error()
+	} else {
+		result["verified"] = true
+	}
+	return result
+}
+
+// describeCertificate extracts the fields worth monitoring from a certificate
+func describeCertificate(cert *x509.Certificate) map[string]interface{} {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses))
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	keyType, keySize := certificateKey(cert)
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	return map[string]interface{}{
+		"subject":            cert.Subject.String(),
+		"issuer":             cert.Issuer.String(),
+		"serialNumber":       cert.SerialNumber.Text(16),
+		"sans":               sans,
+		"notBefore":          cert.NotBefore.UTC().Format(time.RFC3339),
+		"notAfter":           cert.NotAfter.UTC().Format(time.RFC3339),
+		"keyType":            keyType,
+		"keySize":            keySize,
+		"signatureAlgorithm": cert.SignatureAlgorithm.String(),
+		"isCA":               cert.IsCA,
+		"sha256":             hex.EncodeToString(fingerprint[:]),
+	}
+}
+
+// certificateKey returns the public key algorithm and size in bits
+func certificateKey(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return cert.PublicKeyAlgorithm.String(), 0
+	}
+}
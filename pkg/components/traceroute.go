@@ -0,0 +1,468 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	traceDefaultMaxHops = 30
+	traceDefaultProbes  = 3
+	traceDefaultTimeout = 1000
+	traceDefaultUDPPort = 33434
+)
+
+// TracerouteHandler handles traceroute task operations
+type TracerouteHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (th *TracerouteHandler) ValidateData(data map[string]interface{}) error {
+	if err := validateTraceData(data); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "probes", 1, 10); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v Traceroute %v\n", clientIP, data["protocol"])
+	}
+	return nil
+}
+
+// PreProcess resolves the target and creates response structure
+func (th *TracerouteHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	return prepareTraceTarget(data, th.GetTaskType())
+}
+
+// Execute probes each TTL in turn and reports every hop as soon as it is known
+func (th *TracerouteHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	ip := data["ip"].(string)
+	protocol := data["protocol"].(string)
+	port, _ := strconv.Atoi(data["port"].(string))
+	maxHops := getIntParam(data, "maxHops", traceDefaultMaxHops)
+	probes := getIntParam(data, "probes", traceDefaultProbes)
+	timeout := time.Duration(getIntParam(data, "timeout", traceDefaultTimeout)) * time.Millisecond
+
+	tr, err := newTracer(ip, protocol, port, timeout)
+	if err != nil {
+		errorRes := map[string]interface{}{
+			"error":    err.Error(),
+			"taskType": th.GetTaskType(),
+			"taskId":   taskId,
+		}
+		return responseSender.SendMessage("agent-response", errorRes)
+	}
+	defer tr.Close()
+
+	reached := false
+	hops := 0
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		select {
+		case <-stopChan:
+			return fmt.Errorf("task %v received stop signal", taskId)
+		default:
+		}
+
+		hop := tr.Hop(ttl, probes)
+		hops = ttl
+		res := map[string]interface{}{
+			"hop":         hop.TTL,
+			"ip":          hop.IP,
+			"rtts":        hop.RTTs,
+			"timeouts":    hop.Timeouts,
+			"reached":     hop.Reached,
+			"unreachable": hop.Unreachable,
+			"taskType":    th.GetTaskType(),
+			"taskId":      taskId,
+		}
+		if sendErr := responseSender.SendMessage("agent-response", res); sendErr != nil {
+			return sendErr
+		}
+		if hop.Reached || hop.Unreachable {
+			reached = hop.Reached
+			break
+		}
+	}
+
+	// Tell the server that no more hops will follow
+	res := map[string]interface{}{
+		"finished": true,
+		"reached":  reached,
+		"hops":     hops,
+		"taskType": th.GetTaskType(),
+		"taskId":   taskId,
+	}
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// GetTaskType returns the task type identifier
+func (th *TracerouteHandler) GetTaskType() string {
+	return "traceroute"
+}
+
+// prepareTraceTarget resolves the host of a trace-style task and fills in the probe port
+func prepareTraceTarget(data map[string]interface{}, taskType string) (map[string]interface{}, map[string]interface{}, error) {
+	// Clean host string and remove brackets for IPv6
+	host := strings.Trim(data["host"].(string), " \n\"'")
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = strings.Trim(host, "[]")
+	}
+
+	ip, host, port, ipVersion, err := network.FilterIP(host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterIP error: %v", err)
+	}
+
+	protocol := data["protocol"].(string)
+	taskId := data["taskId"].(string)
+
+	// ICMP has no port, UDP starts from the classic traceroute base port
+	switch protocol {
+	case "icmp":
+		port = ""
+	case "udp":
+		port = strconv.Itoa(getIntParam(data, "port", traceDefaultUDPPort))
+	}
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["ip"] = ip
+	processedData["host"] = host
+	processedData["port"] = port
+	processedData["ipVersion"] = ipVersion
+
+	response := map[string]interface{}{
+		"ip":        ip,
+		"port":      port,
+		"ipVersion": ipVersion,
+		"taskType":  taskType,
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// validateTraceData checks the fields shared by trace-style tasks
+func validateTraceData(data map[string]interface{}) error {
+	if data["host"] == nil || data["protocol"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	protocol, _ := data["protocol"].(string)
+	if protocol != "icmp" && protocol != "udp" && protocol != "tcp" {
+		return fmt.Errorf("unsupported protocol: %v", data["protocol"])
+	}
+	if err := checkIntParam(data, "maxHops", 1, 64); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "timeout", 100, 5000); err != nil {
+		return err
+	}
+	return checkIntParam(data, "port", 1, 65535)
+}
+
+// TraceHop holds the probe results for a single TTL
+type TraceHop struct {
+	TTL         int       `json:"hop"`
+	IP          string    `json:"ip"`
+	RTTs        []float64 `json:"rtts"`
+	Timeouts    int       `json:"timeouts"`
+	Reached     bool      `json:"reached"`
+	Unreachable bool      `json:"unreachable"`
+}
+
+// traceReply describes the answer to a single probe
+type traceReply struct {
+	peer        net.IP
+	rtt         time.Duration
+	reached     bool
+	unreachable bool
+}
+
+// tracer sends TTL-limited probes and matches the ICMP errors they trigger
+type tracer struct {
+	dst      net.IP
+	ipv6     bool
+	protocol string
+	port     int
+	timeout  time.Duration
+	icmpConn *icmp.PacketConn
+	id       int
+	seq      int
+	buf      []byte
+}
+
+// newTracer opens the raw ICMP listener used to collect hop replies
+func newTracer(ip string, protocol string, port int, timeout time.Duration) (*tracer, error) {
+	dst := net.ParseIP(ip)
+	if dst == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	t := &tracer{
+		dst:      dst,
+		ipv6:     dst.To4() == nil,
+		protocol: protocol,
+		port:     port,
+		timeout:  timeout,
+		id:       rand.Intn(0xffff) + 1,
+		buf:      make([]byte, 1500),
+	}
+
+	// Hop replies are ICMP errors, which only raw sockets receive
+	if network.GetICMPMode() != network.ICMPModePrivileged {
+		return nil, fmt.Errorf("traceroute requires raw socket privileges (CAP_NET_RAW)")
+	}
+	conn, err := listenICMP(t.ipv6, true)
+	if err != nil {
+		return nil, err
+	}
+	t.icmpConn = conn
+	return t, nil
+}
+
+// Close releases the ICMP listener
+func (t *tracer) Close() {
+	if t.icmpConn != nil {
+		t.icmpConn.Close()
+	}
+}
+
+// Hop sends count probes with the given TTL and aggregates the answers
+func (t *tracer) Hop(ttl int, count int) TraceHop {
+	hop := TraceHop{TTL: ttl, RTTs: []float64{}}
+	for i := 0; i < count; i++ {
+		reply, err := t.Probe(ttl)
+		if err != nil {
+			if !errors.Is(err, errProbeTimeout) {
+				log.Debugf("Trace probe ttl %d failed: %v", ttl, err)
+			}
+			hop.Timeouts++
+			continue
+		}
+		if hop.IP == "" {
+			hop.IP = reply.peer.String()
+		}
+		hop.RTTs = append(hop.RTTs, roundToDecimal(float64(reply.rtt.Microseconds())/1000.0, 3))
+		hop.Reached = hop.Reached || reply.reached
+		hop.Unreachable = hop.Unreachable || reply.unreachable
+	}
+	return hop
+}
+
+// Probe sends one probe with the given TTL using the configured protocol
+func (t *tracer) Probe(ttl int) (traceReply, error) {
+	switch t.protocol {
+	case "udp":
+		return t.probeUDP(ttl)
+	case "tcp":
+		return t.probeTCP(ttl)
+	default:
+		return t.probeICMP(ttl)
+	}
+}
+
+// probeICMP sends an ICMP echo request and waits for the echo reply or an ICMP error quoting it
+func (t *tracer) probeICMP(ttl int) (traceReply, error) {
+	t.seq = (t.seq + 1) & 0xffff
+	seq := t.seq
+
+	var msgType icmp.Type = ipv4.ICMPTypeEcho
+	if t.ipv6 {
+		msgType = ipv6.ICMPTypeEchoRequest
+	}
+	msg := icmp.Message{
+		Type: msgType,
+		Body: &icmp.Echo{ID: t.id, Seq: seq, Data: []byte("adm-agent-trace")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return traceReply{}, err
+	}
+	if err := setICMPConnTTL(t.icmpConn, t.ipv6, ttl); err != nil {
+		return traceReply{}, err
+	}
+
+	start := time.Now()
+	if _, err := t.icmpConn.WriteTo(b, &net.IPAddr{IP: t.dst}); err != nil {
+		return traceReply{}, err
+	}
+
+	deadline := start.Add(t.timeout)
+	for {
+		m, peer, err := t.readICMP(deadline)
+		if err != nil {
+			return traceReply{}, err
+		}
+		if echo, ok := m.Body.(*icmp.Echo); ok {
+			if (m.Type == ipv4.ICMPTypeEchoReply || m.Type == ipv6.ICMPTypeEchoReply) && echo.ID == t.id && echo.Seq == seq {
+				return traceReply{peer: peer, rtt: time.Since(start), reached: true}, nil
+			}
+			continue
+		}
+		if !quotesEcho(m, t.ipv6, t.id, seq) {
+			continue
+		}
+		return t.errorReply(m, peer, start, false), nil
+	}
+}
+
+// probeUDP sends a UDP datagram and waits for time-exceeded or port-unreachable
+func (t *tracer) probeUDP(ttl int) (traceReply, error) {
+	udpNetwork := "udp4"
+	if t.ipv6 {
+		udpNetwork = "udp6"
+	}
+	conn, err := net.ListenPacket(udpNetwork, ":0")
+	if err != nil {
+		return traceReply{}, err
+	}
+	defer conn.Close()
+
+	if t.ipv6 {
+		err = ipv6.NewPacketConn(conn).SetHopLimit(ttl)
+	} else {
+		err = ipv4.NewPacketConn(conn).SetTTL(ttl)
+	}
+	if err != nil {
+		return traceReply{}, err
+	}
+
+	srcPort := conn.LocalAddr().(*net.UDPAddr).Port
+	dstPort := t.port + ttl - 1
+	if dstPort > 65535 {
+		dstPort = t.port
+	}
+
+	start := time.Now()
+	if _, err := conn.WriteTo([]byte("adm-agent-trace"), &net.UDPAddr{IP: t.dst, Port: dstPort}); err != nil {
+		return traceReply{}, err
+	}
+
+	deadline := start.Add(t.timeout)
+	for {
+		m, peer, err := t.readICMP(deadline)
+		if err != nil {
+			return traceReply{}, err
+		}
+		proto, payload, ok := t.quotedPacket(m)
+		if !ok || proto != 17 || len(payload) < 4 {
+			continue
+		}
+		if int(binary.BigEndian.Uint16(payload[0:2])) != srcPort || int(binary.BigEndian.Uint16(payload[2:4])) != dstPort {
+			continue
+		}
+		return t.errorReply(m, peer, start, true), nil
+	}
+}
+
+// probeTCP starts a TTL-limited TCP handshake and waits for the ICMP error or the handshake result
+func (t *tracer) probeTCP(ttl int) (traceReply, error) {
+	tcpNetwork := "tcp4"
+	if t.ipv6 {
+		tcpNetwork = "tcp6"
+	}
+
+	type dialResult struct {
+		err error
+		end time.Time
+	}
+
+	// Bind to an explicit source port so the quoted TCP header can be matched
+	srcPort := 33000 + rand.Intn(28000)
+	dialer := &net.Dialer{
+		Timeout:   t.timeout,
+		LocalAddr: &net.TCPAddr{Port: srcPort},
+		Control:   network.TTLControl(ttl),
+	}
+	dialDone := make(chan dialResult, 1)
+
+	start := time.Now()
+	go func() {
+		conn, err := dialer.Dial(tcpNetwork, net.JoinHostPort(t.dst.String(), strconv.Itoa(t.port)))
+		end := time.Now()
+		if conn != nil {
+			conn.Close()
+		}
+		dialDone <- dialResult{err: err, end: end}
+	}()
+
+	deadline := start.Add(t.timeout)
+	for {
+		select {
+		case res := <-dialDone:
+			if res.err == nil || network.IsConnRefused(res.err) {
+				return traceReply{peer: t.dst, rtt: res.end.Sub(start), reached: true}, nil
+			}
+			if ne, ok := res.err.(net.Error); ok && ne.Timeout() {
+				return traceReply{}, errProbeTimeout
+			}
+			return traceReply{}, res.err
+		default:
+		}
+		if time.Now().After(deadline) {
+			return traceReply{}, errProbeTimeout
+		}
+
+		// Poll the ICMP listener in short slices so the dial result is noticed promptly
+		readDeadline := time.Now().Add(20 * time.Millisecond)
+		if readDeadline.After(deadline) {
+			readDeadline = deadline
+		}
+		m, peer, err := t.readICMP(readDeadline)
+		if err != nil {
+			if errors.Is(err, errProbeTimeout) {
+				continue
+			}
+			return traceReply{}, err
+		}
+		proto, payload, ok := t.quotedPacket(m)
+		if !ok || proto != 6 || len(payload) < 4 {
+			continue
+		}
+		if int(binary.BigEndian.Uint16(payload[0:2])) != srcPort || int(binary.BigEndian.Uint16(payload[2:4])) != t.port {
+			continue
+		}
+		return t.errorReply(m, peer, start, false), nil
+	}
+}
+
+// readICMP reads the next ICMP message before the deadline
+func (t *tracer) readICMP(deadline time.Time) (*icmp.Message, net.IP, error) {
+	return readICMPMessage(t.icmpConn, t.buf, t.ipv6, deadline)
+}
+
+// quotedPacket extracts the transport protocol and header of the datagram quoted in an ICMP error
+func (t *tracer) quotedPacket(m *icmp.Message) (int, []byte, bool) {
+	return icmpQuotedPacket(m, t.ipv6)
+}
+
+// errorReply classifies an ICMP error that quotes one of our probes
+func (t *tracer) errorReply(m *icmp.Message, peer net.IP, start time.Time, portProbe bool) traceReply {
+	reply := traceReply{peer: peer, rtt: time.Since(start)}
+	if m.Type == ipv4.ICMPTypeDestinationUnreachable || m.Type == ipv6.ICMPTypeDestinationUnreachable {
+		// Port unreachable from the target itself means a UDP probe arrived
+		portUnreachable := (!t.ipv6 && m.Code == 3) || (t.ipv6 && m.Code == 4)
+		if portProbe && portUnreachable && peer.Equal(t.dst) {
+			reply.reached = true
+		} else {
+			reply.unreachable = true
+		}
+	}
+	return reply
+}
@@ -0,0 +1,363 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	twampDefaultPort     = "862"
+	twampDefaultCount    = 100
+	twampDefaultInterval = 100
+	twampDefaultTimeout  = 1000
+	twampMaxTTL          = 255
+	twampSenderSize      = 14
+	twampReflectorSize   = 41
+	twampMaxPacketSize   = 1472
+)
+
+// twampErrorEstimate marks the clock as unsynchronized with the smallest nonzero multiplier (RFC 4656 4.1.2)
+const twampErrorEstimate = 0x0001
+
+// TWAMPHandler handles TWAMP-light delay and loss tasks
+type TWAMPHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (th *TWAMPHandler) ValidateData(data map[string]interface{}) error {
+	if data["host"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if err := checkIntParam(data, "count", 1, 1000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "interval", 10, 10000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "size", twampReflectorSize, twampMaxPacketSize); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "timeout", 100, 5000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v TWAMP %v\n", clientIP, data["host"])
+	}
+	return nil
+}
+
+// PreProcess resolves the reflector and applies the TWAMP test port
+func (th *TWAMPHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	// Clean host string and remove brackets for IPv6
+	host := strings.Trim(data["host"].(string), " \n\"'")
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = strings.Trim(host, "[]")
+	}
+
+	ip, hostname, port, ipVersion, err := network.FilterIP(host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterIP error: %v", err)
+	}
+
+	// FilterIP falls back to port 80, reflectors listen on the TWAMP test port 862 by default
+	if _, _, splitErr := net.SplitHostPort(host); splitErr != nil {
+		port = twampDefaultPort
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["ip"] = ip
+	processedData["host"] = hostname
+	processedData["port"] = port
+	processedData["ipVersion"] = ipVersion
+
+	response := map[string]interface{}{
+		"ip":        ip,
+		"port":      port,
+		"ipVersion": ipVersion,
+		"taskType":  th.GetTaskType(),
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute sends the test packets and reports delay, delay variation and loss per direction
+func (th *TWAMPHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	addr := net.JoinHostPort(data["ip"].(string), data["port"].(string))
+	options := TWAMPOptions{
+		Count:    getIntParam(data, "count", twampDefaultCount),
+		Interval: time.Duration(getIntParam(data, "interval", twampDefaultInterval)) * time.Millisecond,
+		Size:     getIntParam(data, "size", twampReflectorSize),
+		Timeout:  time.Duration(getIntParam(data, "timeout", twampDefaultTimeout)) * time.Millisecond,
+	}
+
+	result, err := runTWAMP(addr, options, stopChan)
+
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+	if err != nil {
+		errorRes := map[string]interface{}{
+			"error":    err.Error(),
+			"taskType": th.GetTaskType(),
+			"taskId":   taskId,
+		}
+		return responseSender.SendMessage("agent-response", errorRes)
+	}
+
+	res := result.toMap()
+	res["taskType"] = th.GetTaskType()
+	res["taskId"] = taskId
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// GetTaskType returns the task type identifier
+func (th *TWAMPHandler) GetTaskType() string {
+	return "twamp"
+}
+
+// TWAMPOptions configures a TWAMP-light test session
+type TWAMPOptions struct {
+	Count    int
+	Interval time.Duration
+	Size     int
+	Timeout  time.Duration
+}
+
+// TWAMPResult summarizes a test session, times in milliseconds.
+// Round-trip delay excludes the time spent in the reflector. One-way delays are only meaningful
+// with synchronized clocks, their variation does not depend on the clock offset.
+type TWAMPResult struct {
+	*PingStats
+	Reflected      int
+	ForwardLoss    float64
+	BackwardLoss   float64
+	ForwardDelay   float64
+	BackwardDelay  float64
+	ForwardJitter  float64
+	BackwardJitter float64
+	Hops           int
+}
+
+// toMap converts the result into the response format
+func (tr *TWAMPResult) toMap() map[string]interface{} {
+	res := tr.PingStats.toMap()
+	res["reflected"] = tr.Reflected
+	res["forwardLoss"] = tr.ForwardLoss
+	res["backwardLoss"] = tr.BackwardLoss
+	res["forwardDelay"] = tr.ForwardDelay
+	res["backwardDelay"] = tr.BackwardDelay
+	res["forwardJitter"] = tr.ForwardJitter
+	res["backwardJitter"] = tr.BackwardJitter
+	res["hops"] = tr.Hops
+	return res
+}
+
+// twampReply is a reflected test packet with the four timestamps of its round trip
+type twampReply struct {
+	reflectorSeq uint32
+	ttl          int
+	sent         time.Time
+	reflected    time.Time
+	returned     time.Time
+	received     time.Time
+}
+
+// putTWAMPSenderPacket fills an unauthenticated sender test packet (RFC 5357 4.1.2)
+func putTWAMPSenderPacket(packet []byte, seq uint32, now time.Time) {
+	binary.BigEndian.PutUint32(packet[0:4], seq)
+	binary.BigEndian.PutUint64(packet[4:12], toNTPTime(now))
+	binary.BigEndian.PutUint16(packet[12:14], twampErrorEstimate)
+}
+
+// putTWAMPReflectorPacket fills an unauthenticated reflector test packet (RFC 5357 4.2.1) answering request
+func putTWAMPReflectorPacket(packet, request []byte, seq uint32, received, now time.Time, ttl int) {
+	binary.BigEndian.PutUint32(packet[0:4], seq)
+	binary.BigEndian.PutUint64(packet[4:12], toNTPTime(now))
+	binary.BigEndian.PutUint16(packet[12:14], twampErrorEstimate)
+	packet[14], packet[15] = 0, 0
+	binary.BigEndian.PutUint64(packet[16:24], toNTPTime(received))
+	copy(packet[24:38], request[0:twampSenderSize])
+	packet[38], packet[39] = 0, 0
+	packet[40] = byte(ttl)
+}
+
+// runTWAMP sends count test packets to the reflector at addr and collects the reflected ones
+func runTWAMP(addr string, options TWAMPOptions, stopChan <-chan struct{}) (*TWAMPResult, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve address error: %v", err)
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, fmt.Errorf("dial udp error: %v", err)
+	}
+	defer conn.Close()
+
+	// Senders use TTL 255 so the reflected TTL reveals the forward hop count
+	if raddr.IP.To4() != nil {
+		ipv4.NewConn(conn).SetTTL(twampMaxTTL)
+	} else {
+		ipv6.NewConn(conn).SetHopLimit(twampMaxTTL)
+	}
+
+	var mu sync.Mutex
+	sentTimes := make([]time.Time, options.Count)
+	replies := make(map[uint32]*twampReply)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		buf := make([]byte, 65536)
+		for {
+			n, err := conn.Read(buf)
+			now := time.Now()
+			if err != nil {
+				// Port unreachable errors of a connected socket are transient, the socket is closed to stop
+				if network.IsPortUnreachable(err) {
+					continue
+				}
+				return
+			}
+			if n < twampReflectorSize {
+				continue
+			}
+			seq := binary.BigEndian.Uint32(buf[24:28])
+			mu.Lock()
+			// Match the echoed sender timestamp to skip stray and duplicated packets
+			if int(seq) < len(sentTimes) && replies[seq] == nil && binary.BigEndian.Uint64(buf[28:36]) == toNTPTime(sentTimes[seq]) {
+				replies[seq] = &twampReply{
+					reflectorSeq: binary.BigEndian.Uint32(buf[0:4]),
+					ttl:          int(buf[40]),
+					sent:         sentTimes[seq],
+					reflected:    fromNTPTime(binary.BigEndian.Uint64(buf[16:24])),
+					returned:     fromNTPTime(binary.BigEndian.Uint64(buf[4:12])),
+					received:     now,
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	packet := make([]byte, options.Size)
+	for i := 0; i < options.Count; i++ {
+		select {
+		case <-stopChan:
+			conn.Close()
+			<-readDone
+			return nil, fmt.Errorf("received stop signal")
+		default:
+		}
+
+		start := time.Now()
+		mu.Lock()
+		sentTimes[i] = start
+		putTWAMPSenderPacket(packet, uint32(i), start)
+		mu.Unlock()
+		if _, err := conn.Write(packet); err != nil && !network.IsPortUnreachable(err) {
+			conn.Close()
+			<-readDone
+			return nil, fmt.Errorf("send error: %v", err)
+		}
+
+		if i < options.Count-1 && !waitProbeInterval(start, options.Interval, stopChan) {
+			conn.Close()
+			<-readDone
+			return nil, fmt.Errorf("received stop signal")
+		}
+	}
+
+	// Wait for late replies
+	select {
+	case <-stopChan:
+	case <-time.After(options.Timeout):
+	}
+	conn.Close()
+	<-readDone
+
+	mu.Lock()
+	defer mu.Unlock()
+	return newTWAMPResult(options.Count, replies), nil
+}
+
+// newTWAMPResult derives the session statistics from the replies.
+// The reflector numbers the packets it reflects, so the highest reflector sequence number
+// tells how many packets crossed the forward path.
+func newTWAMPResult(count int, replies map[uint32]*twampReply) *TWAMPResult {
+	probes := make([]PingProbe, count)
+	result := &TWAMPResult{}
+	var forwardSum, backwardSum, forwardVar, backwardVar float64
+	var prev *twampReply
+	received, reflected, pairs := 0, 0, 0
+
+	for i := 0; i < count; i++ {
+		probes[i] = PingProbe{Seq: i + 1, Status: probeStatusTimeout}
+		reply := replies[uint32(i)]
+		if reply == nil {
+			continue
+		}
+		rtt := reply.received.Sub(reply.sent) - reply.returned.Sub(reply.reflected)
+		probes[i].Status = probeStatusOK
+		probes[i].RTT = roundToDecimal(float64(rtt.Microseconds())/1000.0, 3)
+
+		forward := reply.reflected.Sub(reply.sent)
+		backward := reply.received.Sub(reply.returned)
+		forwardSum += float64(forward.Microseconds()) / 1000.0
+		backwardSum += float64(backward.Microseconds()) / 1000.0
+		if prev != nil {
+			forwardVar += math.Abs(float64((forward - prev.reflected.Sub(prev.sent)).Microseconds())) / 1000.0
+			backwardVar += math.Abs(float64((backward - prev.received.Sub(prev.returned)).Microseconds())) / 1000.0
+			pairs++
+		}
+		prev = reply
+
+		if int(reply.reflectorSeq)+1 > reflected {
+			reflected = int(reply.reflectorSeq) + 1
+		}
+		if reply.ttl > 0 {
+			result.Hops = twampMaxTTL - reply.ttl
+		}
+		received++
+	}
+
+	result.PingStats = newPingStats(probes)
+	if reflected > count {
+		reflected = count
+	}
+	if reflected < received {
+		reflected = received
+	}
+	result.Reflected = reflected
+	if count > 0 {
+		result.ForwardLoss = roundToDecimal(float64(count-reflected)/float64(count)*100, 2)
+	}
+	if reflected > 0 {
+		result.BackwardLoss = roundToDecimal(float64(reflected-received)/float64(reflected)*100, 2)
+	}
+	if received > 0 {
+		result.ForwardDelay = roundToDecimal(forwardSum/float64(received), 3)
+		result.BackwardDelay = roundToDecimal(backwardSum/float64(received), 3)
+	}
+	if pairs > 0 {
+		result.ForwardJitter = roundToDecimal(forwardVar/float64(pairs), 3)
+		result.BackwardJitter = roundToDecimal(backwardVar/float64(pairs), 3)
+	}
+	return result
+}
@@ -0,0 +1,319 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"github.com/miekg/dns"
+)
+
+const (
+	udpDefaultCount    = 3
+	udpDefaultInterval = 1000
+	udpDefaultTimeout  = 1000
+	udpMaxPayloadSize  = 1472
+	udpReplyPreview    = 64
+)
+
+// udpPresetPorts holds the default port of each built-in payload
+var udpPresetPorts = map[string]string{
+	"dns":  "53",
+	"ntp":  "123",
+	"stun": "3478",
+}
+
+// udpPayloadFunc builds a fresh probe payload and a matcher that recognizes its reply
+type udpPayloadFunc func() ([]byte, func(reply []byte) bool)
+
+// UDPHandler handles UDP reachability and latency tasks
+type UDPHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (uh *UDPHandler) ValidateData(data map[string]interface{}) error {
+	if data["host"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if _, err := udpPayload(data); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "count", 1, 100); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "interval", 100, 60000); err != nil {
+		return err
+	}
+	if err := checkIntParam(data, "timeout", 100, 5000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v UDP %v\n", clientIP, data["host"])
+	}
+	return nil
+}
+
+// PreProcess resolves the target and picks the port of the built-in payload when none is given
+func (uh *UDPHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	// Clean host string and remove brackets for IPv6
+	host := strings.Trim(data["host"].(string), " \n\"'")
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = strings.Trim(host, "[]")
+	}
+
+	ip, hostname, port, ipVersion, err := network.FilterIP(host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterIP error: %v", err)
+	}
+
+	// FilterIP falls back to port 80, UDP targets need an explicit or preset port
+	if _, _, splitErr := net.SplitHostPort(host); splitErr != nil {
+		presetPort, ok := udpPresetPorts[strings.ToLower(getStringParam(data, "preset", ""))]
+		if !ok {
+			return nil, nil, fmt.Errorf("port is required for custom payloads")
+		}
+		port = presetPort
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["ip"] = ip
+	processedData["host"] = hostname
+	processedData["port"] = port
+	processedData["ipVersion"] = ipVersion
+
+	response := map[string]interface{}{
+		"ip":        ip,
+		"port":      port,
+		"ipVersion": ipVersion,
+		"taskType":  uh.GetTaskType(),
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute sends the probes and reports their statistics
+func (uh *UDPHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	ip := data["ip"].(string)
+	port := data["port"].(string)
+	count := getIntParam(data, "count", udpDefaultCount)
+	interval := time.Duration(getIntParam(data, "interval", udpDefaultInterval)) * time.Millisecond
+	timeout := time.Duration(getIntParam(data, "timeout", udpDefaultTimeout)) * time.Millisecond
+
+	payload, err := udpPayload(data)
+	if err == nil {
+		var prober *udpProber
+		prober, err = newUDPProber(net.JoinHostPort(ip, port), payload)
+		if err == nil {
+			defer prober.Close()
+
+			probes := make([]PingProbe, 0, count)
+			for i := 0; i < count; i++ {
+				select {
+				case <-stopChan:
+					return fmt.Errorf("task %v received stop signal", taskId)
+				default:
+				}
+
+				startTime := time.Now()
+				probes = append(probes, prober.Probe(i+1, timeout))
+
+				// Keep probes at least one interval apart
+				if i < count-1 {
+					if remainingTime := interval - time.Since(startTime); remainingTime > 0 {
+						select {
+						case <-stopChan:
+							return fmt.Errorf("task %v received stop signal", taskId)
+						case <-time.After(remainingTime):
+						}
+					}
+				}
+			}
+
+			res := newPingStats(probes).toMap()
+			res["replySize"] = len(prober.reply)
+			res["replyHex"] = hex.EncodeToString(prober.reply)
+			res["taskType"] = uh.GetTaskType()
+			res["taskId"] = taskId
+			return responseSender.SendMessage("agent-response", res)
+		}
+	}
+
+	errorRes := map[string]interface{}{
+		"error":    err.Error(),
+		"taskType": uh.GetTaskType(),
+		"taskId":   taskId,
+	}
+	return responseSender.SendMessage("agent-response", errorRes)
+}
+
+// GetTaskType returns the task type identifier
+func (uh *UDPHandler) GetTaskType() string {
+	return "udp"
+}
+
+// udpPayload selects a built-in payload or decodes the caller supplied one
+func udpPayload(data map[string]interface{}) (udpPayloadFunc, error) {
+	switch preset := strings.ToLower(getStringParam(data, "preset", "")); preset {
+	case "dns":
+		return dnsProbePayload, nil
+	case "ntp":
+		return ntpProbePayload, nil
+	case "stun":
+		return stunProbePayload, nil
+	case "":
+	default:
+		return nil, fmt.Errorf("unsupported preset: %s", preset)
+	}
+
+	raw := getStringParam(data, "payload", "")
+	var payload []byte
+	var err error
+	switch encoding := strings.ToLower(getStringParam(data, "encoding", "text")); encoding {
+	case "text":
+		payload = []byte(raw)
+	case "hex":
+		payload, err = hex.DecodeString(strings.ReplaceAll(raw, " ", ""))
+	case "base64":
+		payload, err = base64.StdEncoding.DecodeString(raw)
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	if len(payload) > udpMaxPayloadSize {
+		return nil, fmt.Errorf("payload must not exceed %d bytes", udpMaxPayloadSize)
+	}
+
+	// Any datagram from the target answers a custom payload
+	return func() ([]byte, func([]byte) bool) {
+		return payload, func([]byte) bool { return true }
+	}, nil
+}
+
+// dnsProbePayload queries the root NS set and matches the reply by message ID
+func dnsProbePayload() ([]byte, func([]byte) bool) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(".", dns.TypeNS)
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, func([]byte) bool { return false }
+	}
+	return packed, func(reply []byte) bool {
+		return len(reply) >= 12 && bytes.Equal(reply[:2], packed[:2]) && reply[2]&0x80 != 0
+	}
+}
+
+// ntpProbePayload sends an NTPv4 client request and matches the server reply by its origin timestamp
+func ntpProbePayload() ([]byte, func([]byte) bool) {
+	packet := make([]byte, 48)
+	packet[0] = 0x23 // LI 0, version 4, mode 3 (client)
+	rand.Read(packet[40:48])
+	return packet, func(reply []byte) bool {
+		return len(reply) >= 48 && reply[0]&0x07 == 4 && bytes.Equal(reply[24:32], packet[40:48])
+	}
+}
+
+// stunProbePayload sends a STUN binding request and matches the response by transaction ID
+func stunProbePayload() ([]byte, func([]byte) bool) {
+	packet := make([]byte, 20)
+	binary.BigEndian.PutUint16(packet[0:2], 0x0001)
+	binary.BigEndian.PutUint32(packet[4:8], 0x2112A442)
+	rand.Read(packet[8:20])
+	return packet, func(reply []byte) bool {
+		return len(reply) >= 20 && reply[0]&0xc0 == 0 && bytes.Equal(reply[8:20], packet[8:20])
+	}
+}
+
+// udpProber sends payloads over a connected UDP socket so ICMP errors are reported on read
+type udpProber struct {
+	conn    *net.UDPConn
+	payload udpPayloadFunc
+	buf     []byte
+	reply   []byte
+}
+
+// newUDPProber connects a UDP socket to addr
+func newUDPProber(addr string, payload udpPayloadFunc) (*udpProber, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve address error: %v", err)
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, fmt.Errorf("dial udp error: %v", err)
+	}
+	return &udpProber{conn: conn, payload: payload, buf: make([]byte, 65536)}, nil
+}
+
+// Close releases the UDP socket
+func (up *udpProber) Close() {
+	up.conn.Close()
+}
+
+// Probe sends one payload and waits for a matching reply
+func (up *udpProber) Probe(seq int, timeout time.Duration) PingProbe {
+	probe := PingProbe{Seq: seq, Status: probeStatusTimeout}
+	payload, match := up.payload()
+
+	start := time.Now()
+	if _, err := up.conn.Write(payload); err != nil {
+		probe.Status = udpProbeStatus(err)
+		return probe
+	}
+
+	if err := up.conn.SetReadDeadline(start.Add(timeout)); err != nil {
+		probe.Status = probeStatusUnreachable
+		return probe
+	}
+	for {
+		n, err := up.conn.Read(up.buf)
+		if err != nil {
+			probe.Status = udpProbeStatus(err)
+			return probe
+		}
+		// Skip late replies to earlier probes
+		if !match(up.buf[:n]) {
+			continue
+		}
+		probe.Status = probeStatusOK
+		probe.RTT = roundToDecimal(float64(time.Since(start).Microseconds())/1000.0, 3)
+		preview := n
+		if preview > udpReplyPreview {
+			preview = udpReplyPreview
+		}
+		up.reply = append(up.reply[:0], up.buf[:preview]...)
+		return probe
+	}
+}
+
+// udpProbeStatus classifies a failed UDP send or receive
+func udpProbeStatus(err error) string {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return probeStatusTimeout
+	}
+	if network.IsPortUnreachable(err) {
+		return probeStatusRefused
+	}
+	if network.IsMsgTooLarge(err) {
+		return probeStatusTooBig
+	}
+	return probeStatusUnreachable
+}
@@ -4,6 +4,7 @@
 package components
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
@@ -15,10 +16,13 @@ import (
 	"net/http/httptrace"
 	neturl "net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/admuu/adm-agent/pkg/network"
 	"github.com/admuu/adm-agent/pkg/utils"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 )
 
 var log = utils.GetLogger()
@@ -31,6 +35,9 @@ func (wh *WebspeedHandler) ValidateData(data map[string]interface{}) error {
 	if data["content"] == nil || data["type"] == nil || data["taskId"] == nil {
 		return fmt.Errorf("event data format invalid: missing required fields")
 	}
+	if _, err := parseHTTPProbeOptions(data); err != nil {
+		return err
+	}
 	if clientIP := data["clientIP"]; clientIP != nil {
 		log.Infof("%v Webspeed\n", clientIP)
 	}
@@ -80,7 +87,11 @@ func (wh *WebspeedHandler) Execute(data map[string]interface{}, taskId string, s
 		url := data["url"].(string)
 		ip := data["ip"].(string)
 
-		result, err := wh.webSpeedTest(url, ip)
+		var result *WebSpeedTestResult
+		options, err := parseHTTPProbeOptions(data)
+		if err == nil {
+			result, err = wh.webSpeedTest(url, ip, options)
+		}
 		if err != nil {
 			// Send error response
 			errorRes := map[string]interface{}{
@@ -93,20 +104,28 @@ func (wh *WebspeedHandler) Execute(data map[string]interface{}, taskId string, s
 
 		// Send success response
 		res := map[string]interface{}{
-			"httpCode":      result.HTTPCode,
-			"totalTime":     result.TotalTime,
-			"dnsTime":       result.DNSTime,
-			"connectTime":   result.ConnectTime,
-			"sslTime":       result.SSLTime,
-			"waitTime":      result.WaitTime,
-			"downloadTime":  result.DownloadTime,
-			"downloadSize":  result.DownloadSize,
-			"downloadSpeed": result.DownloadSpeed,
-			"redirectCount": result.RedirectCount,
-			"redirectTime":  result.RedirectTime,
-			"httpHeaders":   result.HTTPHeaders,
-			"taskType":      wh.GetTaskType(),
-			"taskId":        taskId,
+			"httpCode":         result.HTTPCode,
+			"totalTime":        result.TotalTime,
+			"dnsTime":          result.DNSTime,
+			"connectTime":      result.ConnectTime,
+			"sslTime":          result.SSLTime,
+			"waitTime":         result.WaitTime,
+			"downloadTime":     result.DownloadTime,
+			"downloadSize":     result.DownloadSize,
+			"downloadSpeed":    result.DownloadSpeed,
+			"redirectCount":    result.RedirectCount,
+			"redirectTime":     result.RedirectTime,
+			"httpHeaders":      result.HTTPHeaders,
+			"passed":           result.Passed,
+			"assertions":       result.Assertions,
+			"hops":             result.Hops,
+			"resolve":          result.ResolveMode,
+			"resolvedIP":       result.ResolvedIP,
+			"httpVersion":      result.HTTPVersion,
+			"protocol":         result.Protocol,
+			"protocolFallback": result.ProtocolFallback,
+			"taskType":         wh.GetTaskType(),
+			"taskId":           taskId,
 		}
 		return responseSender.SendMessage("agent-response", res)
 	}
@@ -119,18 +138,42 @@ func (wh *WebspeedHandler) GetTaskType() string {
 
 // WebSpeedTestResult contains web speed test results
 type WebSpeedTestResult struct {
-	HTTPCode      int     `json:"httpCode"`
-	TotalTime     float64 `json:"totalTime"`
-	DNSTime       float64 `json:"dnsTime"`
-	ConnectTime   float64 `json:"connectTime"`
-	SSLTime       float64 `json:"sslTime"`
-	WaitTime      float64 `json:"waitTime"`
-	DownloadTime  float64 `json:"downloadTime"`
-	DownloadSize  int64   `json:"downloadSize"`
-	DownloadSpeed float64 `json:"downloadSpeed"`
-	RedirectCount int     `json:"redirectCount"`
-	RedirectTime  float64 `json:"redirectTime"`
-	HTTPHeaders   string  `json:"httpHeaders"`
+	HTTPCode      int             `json:"httpCode"`
+	TotalTime     float64         `json:"totalTime"`
+	DNSTime       float64         `json:"dnsTime"`
+	ConnectTime   float64         `json:"connectTime"`
+	SSLTime       float64         `json:"sslTime"`
+	WaitTime      float64         `json:"waitTime"`
+	DownloadTime  float64         `json:"downloadTime"`
+	DownloadSize  int64           `json:"downloadSize"`
+	DownloadSpeed float64         `json:"downloadSpeed"`
+	RedirectCount int             `json:"redirectCount"`
+	RedirectTime  float64         `json:"redirectTime"`
+	HTTPHeaders   string          `json:"httpHeaders"`
+	Passed        bool            `json:"passed"`
+	Assertions    []HTTPAssertion `json:"assertions"`
+	Hops          []RedirectHop   `json:"hops"`
+	ResolveMode   string          `json:"resolve"`
+	ResolvedIP    string          `json:"resolvedIP"`
+	HTTPVersion   string          `json:"httpVersion"`
+	Protocol      string          `json:"protocol"`
+	// ProtocolFallback is set when the server did not negotiate the requested HTTP/2
+	ProtocolFallback bool `json:"protocolFallback"`
+}
+
+// RedirectHop describes one request of a redirect chain, times in milliseconds
+type RedirectHop struct {
+	URL         string            `json:"url"`
+	Status      int               `json:"status"`
+	Proto       string            `json:"proto"`
+	IP          string            `json:"ip"`
+	Reused      bool              `json:"reused"`
+	DNSTime     float64           `json:"dnsTime"`
+	ConnectTime float64           `json:"connectTime"`
+	TLSTime     float64           `json:"tlsTime"`
+	TTFB        float64           `json:"ttfb"`
+	Headers     map[string]string `json:"headers"`
+	Error       string            `json:"error,omitempty"`
 }
 
 // roundToDecimal rounds value to specified decimal places
@@ -155,19 +198,80 @@ func getRandomUserAgent() string {
 	return userAgents[rand.Intn(len(userAgents))]
 }
 
+// elapsedMs returns the milliseconds between two trace events, or 0 if either is missing
+func elapsedMs(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return roundToDecimal(float64(end.Sub(start).Microseconds())/1000.0, 3)
+}
+
 // RedirectCapturingRoundTripper captures redirect responses and headers
 type RedirectCapturingRoundTripper struct {
 	Transport     http.RoundTripper
 	AllHeaders    *strings.Builder
 	RedirectCount int
+	Hops          []RedirectHop
 }
 
 func (rt *RedirectCapturingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	hop := RedirectHop{URL: req.URL.String()}
+
+	// Trace events may fire from dialing goroutines, so guard the timestamps
+	var mu sync.Mutex
+	var dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone, firstByte time.Time
+	at := func(t *time.Time, keepFirst bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !keepFirst || t.IsZero() {
+			*t = time.Now()
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { at(&dnsStart, true) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { at(&dnsDone, false) },
+		ConnectStart:      func(string, string) { at(&connectStart, true) },
+		ConnectDone:       func(string, string, error) { at(&connectDone, false) },
+		TLSHandshakeStart: func() { at(&tlsStart, true) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { at(&tlsDone, false) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			hop.Reused = info.Reused
+			if info.Conn != nil {
+				if h, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+					hop.IP = h
+				}
+			}
+		},
+		GotFirstResponseByte: func() { at(&firstByte, true) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
 	resp, err := rt.Transport.RoundTrip(req)
+
+	mu.Lock()
+	hop.DNSTime = elapsedMs(dnsStart, dnsDone)
+	hop.ConnectTime = elapsedMs(connectStart, connectDone)
+	hop.TLSTime = elapsedMs(tlsStart, tlsDone)
+	hop.TTFB = elapsedMs(start, firstByte)
+	mu.Unlock()
+
 	if err != nil {
+		hop.Error = err.Error()
+		rt.Hops = append(rt.Hops, hop)
 		return resp, err
 	}
 
+	hop.Status = resp.StatusCode
+	hop.Proto = resp.Proto
+	hop.Headers = make(map[string]string, len(resp.Header))
+	for key, values := range resp.Header {
+		hop.Headers[key] = strings.Join(values, ", ")
+	}
+	rt.Hops = append(rt.Hops, hop)
+
 	// Capture redirect response headers
 	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
 		rt.RedirectCount++
@@ -184,8 +288,76 @@ func (rt *RedirectCapturingRoundTripper) RoundTrip(req *http.Request) (*http.Res
 	return resp, err
 }
 
+// newHTTP3Transport creates a QUIC transport that dials like the TCP transport of webSpeedTest.
+// The QUIC handshake is reported through the TLS handshake trace hooks.
+func newHTTP3Transport(host, targetIP string, pinned bool, handshakeTimeout time.Duration) *http3.Transport {
+	return &http3.Transport{
+		QUICConfig: &quic.Config{HandshakeIdleTimeout: handshakeTimeout},
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+			h, p, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to split host port %s: %v", addr, err)
+			}
+
+			ips := []string{targetIP}
+			if h != host || !pinned {
+				// ctx carries the request trace, so the lookup fires DNSStart and DNSDone like on the TCP path
+				addrs, err := net.DefaultResolver.LookupIPAddr(ctx, h)
+				if err != nil {
+					return nil, err
+				}
+				if len(addrs) == 0 {
+					return nil, fmt.Errorf("no IP addresses found for host: %s", h)
+				}
+				ips = ips[:0]
+				for _, a := range addrs {
+					ips = append(ips, a.IP.String())
+				}
+			}
+
+			// Addresses are tried in turn like the TCP dialer does, GotConn then reports the one dialed
+			var lastErr error
+			for _, ip := range ips {
+				conn, err := dialQUIC(ctx, net.JoinHostPort(ip, p), tlsCfg, cfg)
+				if err == nil {
+					return conn, nil
+				}
+				lastErr = err
+			}
+			return nil, lastErr
+		},
+	}
+}
+
+// dialQUIC completes a QUIC handshake with addr and reports it through the TLS handshake trace hooks
+func dialQUIC(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	log.Debugf("Dialing QUIC address: %s", addr)
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	conn, err := quic.DialAddrEarly(ctx, addr, tlsCfg, cfg)
+	if err == nil {
+		select {
+		case <-conn.HandshakeComplete():
+		case <-ctx.Done():
+			conn.CloseWithError(0, "")
+			conn, err = nil, ctx.Err()
+		}
+	}
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		var state tls.ConnectionState
+		if conn != nil {
+			state = conn.ConnectionState().TLS
+		}
+		trace.TLSHandshakeDone(state, err)
+	}
+	return conn, err
+}
+
 // webSpeedTest performs the actual web speed test
-func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResult, error) {
+func (wh *WebspeedHandler) webSpeedTest(url, targetIP string, options HTTPProbeOptions) (*WebSpeedTestResult, error) {
     const (
         maxRedirects    = 5
         connectTimeout  = 2 * time.Second
@@ -200,6 +372,9 @@ func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResu
     if err != nil {
         return nil, fmt.Errorf("parse URL error: %v", err)
     }
+    if (options.HTTPVersion == httpVersion2 || options.HTTPVersion == httpVersion3) && parsedURL.Scheme != "https" {
+        return nil, fmt.Errorf("HTTP/%s requires an https URL", options.HTTPVersion)
+    }
 
     host := parsedURL.Hostname()
     port := parsedURL.Port()
@@ -211,16 +386,19 @@ func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResu
         }
     }
 
-    // DNS lookup timing with better error handling
-    log.Debugf("Starting DNS lookup for host: %s", host)
-    dnsStart := time.Now()
-    dnsIPs, dnsErr := net.LookupIP(host)
-    dnsEnd := time.Now()
+    // In pinned mode the lookup is timed separately, in dial mode the trace hooks time it
+    var dnsStart, dnsEnd time.Time
+    if options.Resolve == httpResolvePinned {
+        log.Debugf("Starting DNS lookup for host: %s", host)
+        dnsStart = time.Now()
+        dnsIPs, dnsErr := net.LookupIP(host)
+        dnsEnd = time.Now()
 
-    if dnsErr != nil {
-        log.Debugf("DNS lookup failed for %s: %v", host, dnsErr)
-    } else {
-        log.Debugf("DNS lookup successful for %s: %v", host, dnsIPs)
+        if dnsErr != nil {
+            log.Debugf("DNS lookup failed for %s: %v", host, dnsErr)
+        } else {
+            log.Debugf("DNS lookup successful for %s: %v", host, dnsIPs)
+        }
     }
 
     dialer := &net.Dialer{Timeout: connectTimeout}
@@ -236,7 +414,7 @@ func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResu
                 return nil, fmt.Errorf("failed to split host port %s: %v", addr, err)
             }
 
-            if h == host {
+            if h == host && options.Resolve == httpResolvePinned {
                 ip := targetIP
                 // if strings.Contains(ip, ":") && !strings.HasPrefix(ip, "[") {
                 //     ip = "[" + ip + "]"
@@ -252,8 +430,25 @@ func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResu
         TLSHandshakeTimeout: connectTimeout,
     }
 
+	// Select the protocol, HTTP/2 is negotiated through ALPN on TLS connections
+	var roundTripper http.RoundTripper = baseTransport
+	switch options.HTTPVersion {
+	case httpVersionAuto:
+		// Left as before HTTP/2 was selectable, the custom dialer keeps this transport on HTTP/1.1
+	case httpVersion1:
+		// A non-nil empty map disables the HTTP/2 upgrade
+		baseTransport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case httpVersion2:
+		// ALPN offers h2 and http/1.1, a server without HTTP/2 is reported as a fallback
+		baseTransport.ForceAttemptHTTP2 = true
+	case httpVersion3:
+		h3Transport := newHTTP3Transport(host, targetIP, options.Resolve == httpResolvePinned, connectTimeout)
+		defer h3Transport.Close()
+		roundTripper = h3Transport
+	}
+
 	customTransport := &RedirectCapturingRoundTripper{
-		Transport:     baseTransport,
+		Transport:     roundTripper,
 		AllHeaders:    &allHttpHeaders,
 		RedirectCount: 0,
 	}
@@ -280,9 +475,25 @@ func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResu
 	var connectStart, sslStart time.Time
 	var connectEnd, sslEnd time.Time
 	var firstByteTime time.Time
+	var resolvedIP string
 
 	// HTTP trace for detailed timing
 	trace := &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			if dnsStart.IsZero() {
+				dnsStart = time.Now()
+			}
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if dnsEnd.IsZero() {
+				dnsEnd = time.Now()
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if resolvedIP == "" && info.Conn != nil {
+				resolvedIP, _, _ = net.SplitHostPort(info.Conn.RemoteAddr().String())
+			}
+		},
 		ConnectStart: func(network, addr string) { connectStart = time.Now() },
 		ConnectDone:  func(network, addr string, err error) { connectEnd = time.Now() },
 		TLSHandshakeStart: func() { sslStart = time.Now() },
@@ -291,15 +502,35 @@ func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResu
 	}
 
 	// Create and configure request
-	req, err := http.NewRequest("GET", url, nil)
+	var reqBody io.Reader
+	if options.Body != "" {
+		reqBody = strings.NewReader(options.Body)
+	}
+	req, err := http.NewRequest(options.Method, url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("create request error: %v", err)
 	}
 	req = req.WithContext(httptrace.WithClientTrace(context.Background(), trace))
 
-	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-	req.Header.Set("User-Agent", getRandomUserAgent())
+	// Brotli cannot be decoded with the standard library, so skip it when the body is inspected
+	if options.needsBody() {
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	} else {
+		req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	}
+	userAgent := options.UserAgent
+	if userAgent == "" {
+		userAgent = getRandomUserAgent()
+	}
+	req.Header.Set("User-Agent", userAgent)
 	req.Host = host
+	for key, value := range options.Headers {
+		if strings.EqualFold(key, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(key, value)
+	}
 
 	// Execute request and measure total time
 	totalStart := time.Now()
@@ -309,7 +540,14 @@ func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResu
 	}
 	defer resp.Body.Close()
 
+	result.HTTPVersion = options.HTTPVersion
+	result.Protocol = resp.Proto
+	result.ProtocolFallback = options.HTTPVersion == httpVersion2 && resp.ProtoMajor != 2
+
 	result.RedirectCount = customTransport.RedirectCount
+	result.Hops = customTransport.Hops
+	result.ResolveMode = options.Resolve
+	result.ResolvedIP = resolvedIP
 	result.HTTPCode = resp.StatusCode
 
 	// Calculate redirect time
@@ -342,6 +580,7 @@ func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResu
 	// Download data with size and time limits
 	buffer := make([]byte, 8192)
 	var totalDownloaded int64
+	var bodyBuffer bytes.Buffer
 
 	downloadCtx, downloadCancel := context.WithTimeout(context.Background(), maxDownloadTime)
 	defer downloadCancel()
@@ -363,9 +602,15 @@ func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResu
 				if totalDownloaded+int64(n) > maxDownloadSize {
 					remainingBytes := maxDownloadSize - totalDownloaded
 					totalDownloaded += remainingBytes
+					if options.needsBody() {
+						bodyBuffer.Write(buffer[:remainingBytes])
+					}
 					return
 				}
 				totalDownloaded += int64(n)
+				if options.needsBody() {
+					bodyBuffer.Write(buffer[:n])
+				}
 			}
 
 			if err != nil {
@@ -431,5 +676,14 @@ func (wh *WebspeedHandler) webSpeedTest(url, targetIP string) (*WebSpeedTestResu
 	result.DownloadSize = totalDownloaded
 	result.DownloadSpeed = roundToDecimal(downloadSpeed, 2)
 
+	var body []byte
+	if options.needsBody() {
+		body, err = decodeHTTPBody(bodyBuffer.Bytes(), resp.Header.Get("Content-Encoding"))
+		if err != nil {
+			log.Debugf("Decode response body failed: %v", err)
+		}
+	}
+	result.Assertions, result.Passed = options.check(resp.StatusCode, body)
+
 	return result, nil
 }
\ No newline at end of file
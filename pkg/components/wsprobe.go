@@ -0,0 +1,352 @@
+// Copyright 2024-2025 Admin.IM <dev@admin.im>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package components
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admuu/adm-agent/pkg/network"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsProbeDefaultTimeout = 10000
+	wsProbeReplyPreview   = 256
+	wsProbeMaxMessageSize = 65536
+)
+
+// WSProbeHandler handles WebSocket endpoint probe tasks
+type WSProbeHandler struct{}
+
+// ValidateData checks if required fields are present in the data
+func (wh *WSProbeHandler) ValidateData(data map[string]interface{}) error {
+	if data["url"] == nil || data["taskId"] == nil {
+		return fmt.Errorf("event data format invalid: missing required fields")
+	}
+	if _, err := parseWSProbeURL(data["url"].(string)); err != nil {
+		return err
+	}
+	if data["headers"] != nil {
+		if _, err := parseHTTPHeaders(data["headers"]); err != nil {
+			return err
+		}
+	}
+	if len(getStringParam(data, "message", "")) > wsProbeMaxMessageSize {
+		return fmt.Errorf("message must not exceed %d bytes", wsProbeMaxMessageSize)
+	}
+	if err := checkIntParam(data, "timeout", 1000, 30000); err != nil {
+		return err
+	}
+	if clientIP := data["clientIP"]; clientIP != nil {
+		log.Infof("%v WSProbe %v\n", clientIP, data["url"])
+	}
+	return nil
+}
+
+// PreProcess resolves the host of the URL
+func (wh *WSProbeHandler) PreProcess(data map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	u, err := parseWSProbeURL(data["url"].(string))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	ip, _, _, ipVersion, err := network.FilterIP(net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, nil, fmt.Errorf("filterIP error: %v", err)
+	}
+
+	taskId := data["taskId"].(string)
+
+	processedData := make(map[string]interface{})
+	for k, v := range data {
+		processedData[k] = v
+	}
+	processedData["url"] = u.String()
+	processedData["ip"] = ip
+
+	response := map[string]interface{}{
+		"ip":        ip,
+		"port":      port,
+		"ipVersion": ipVersion,
+		"taskType":  wh.GetTaskType(),
+		"taskId":    taskId,
+	}
+
+	return processedData, response, nil
+}
+
+// Execute opens the WebSocket, optionally exchanges a message and closes it
+func (wh *WSProbeHandler) Execute(data map[string]interface{}, taskId string, stopChan <-chan struct{}, responseSender ResponseSender) error {
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	options := WSProbeOptions{
+		URL:          data["url"].(string),
+		Subprotocols: getStringListParam(data, "subprotocols"),
+		Message:      getStringParam(data, "message", ""),
+		Insecure:     getBoolParam(data, "insecure"),
+		Timeout:      time.Duration(getIntParam(data, "timeout", wsProbeDefaultTimeout)) * time.Millisecond,
+	}
+	if data["headers"] != nil {
+		headers, _ := parseHTTPHeaders(data["headers"])
+		options.Header = http.Header{}
+		for key, value := range headers {
+			options.Header.Set(key, value)
+		}
+	}
+
+	result, err := probeWebSocket(options)
+
+	select {
+	case <-stopChan:
+		return fmt.Errorf("task %v received stop signal", taskId)
+	default:
+	}
+
+	res := map[string]interface{}{
+		"url":             options.URL,
+		"resolvedIP":      result.ResolvedIP,
+		"statusCode":      result.StatusCode,
+		"subprotocol":     result.Subprotocol,
+		"dnsTime":         result.DNSTime,
+		"connectTime":     result.ConnectTime,
+		"tlsTime":         result.TLSTime,
+		"upgradeTime":     result.UpgradeTime,
+		"totalTime":       result.TotalTime,
+		"responseHeaders": result.ResponseHeaders,
+		"closeCode":       result.CloseCode,
+		"closeText":       result.CloseText,
+		"closedBy":        result.ClosedBy,
+		"taskType":        wh.GetTaskType(),
+		"taskId":          taskId,
+	}
+	if options.Message != "" {
+		res["replyTime"] = result.ReplyTime
+		res["replySize"] = result.ReplySize
+		res["reply"] = result.Reply
+	}
+	if err != nil {
+		res["error"] = err.Error()
+	}
+	return responseSender.SendMessage("agent-response", res)
+}
+
+// GetTaskType returns the task type identifier
+func (wh *WSProbeHandler) GetTaskType() string {
+	return "wsprobe"
+}
+
+// parseWSProbeURL accepts ws and wss URLs, http and https are mapped onto them
+func parseWSProbeURL(raw string) (*neturl.URL, error) {
+	u, err := neturl.Parse(strings.Trim(raw, " \n\"'"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid url: missing host")
+	}
+	return u, nil
+}
+
+// WSProbeOptions configures a WebSocket probe
+type WSProbeOptions struct {
+	URL          string
+	Header       http.Header
+	Subprotocols []string
+	Message      string
+	Insecure     bool
+	Timeout      time.Duration
+}
+
+// WSProbeResult holds the timings and close details of a WebSocket probe, times in milliseconds
+type WSProbeResult struct {
+	ResolvedIP      string
+	StatusCode      int
+	Subprotocol     string
+	ResponseHeaders map[string]string
+	DNSTime         float64
+	ConnectTime     float64
+	TLSTime         float64
+	UpgradeTime     float64
+	ReplyTime       float64
+	ReplySize       int
+	Reply           string
+	TotalTime       float64
+	CloseCode       int
+	CloseText       string
+	ClosedBy        string
+}
+
+// probeWebSocket dials the endpoint with a traced handshake, exchanges the optional message and
+// performs the closing handshake. The result is filled as far as the probe got when an error is returned.
+func probeWebSocket(options WSProbeOptions) (*WSProbeResult, error) {
+	result := &WSProbeResult{}
+
+	var mu sync.Mutex
+	var dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			dnsStart = time.Now()
+			mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			dnsDone = time.Now()
+			mu.Unlock()
+		},
+		// The dialer may try several addresses, keep the last attempt
+		ConnectStart: func(_, _ string) {
+			mu.Lock()
+			connectStart = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(_, addr string, err error) {
+			mu.Lock()
+			if err == nil {
+				connectDone = time.Now()
+				if host, _, splitErr := net.SplitHostPort(addr); splitErr == nil {
+					result.ResolvedIP = host
+				}
+			}
+			mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			tlsDone = time.Now()
+			mu.Unlock()
+		},
+	}
+
+	start := time.Now()
+	deadline := start.Add(options.Timeout)
+	ctx, cancel := context.WithDeadline(httptrace.WithClientTrace(context.Background(), trace), deadline)
+	defer cancel()
+
+	ws := network.Websocket{
+		Url:                options.URL,
+		Timeout:            (options.Timeout + time.Second - 1) / time.Second,
+		Header:             options.Header,
+		Subprotocols:       options.Subprotocols,
+		InsecureSkipVerify: options.Insecure,
+	}
+	conn, resp, err := ws.DialContext(ctx)
+	upgraded := time.Now()
+
+	mu.Lock()
+	result.DNSTime = elapsedMs(dnsStart, dnsDone)
+	result.ConnectTime = elapsedMs(connectStart, connectDone)
+	result.TLSTime = elapsedMs(tlsStart, tlsDone)
+	mu.Unlock()
+
+	if resp != nil {
+		// The upgrade starts once the transport is ready
+		if !tlsDone.IsZero() {
+			result.UpgradeTime = elapsedMs(tlsDone, upgraded)
+		} else {
+			result.UpgradeTime = elapsedMs(connectDone, upgraded)
+		}
+		result.StatusCode = resp.StatusCode
+		result.ResponseHeaders = make(map[string]string)
+		for key := range resp.Header {
+			result.ResponseHeaders[key] = resp.Header.Get(key)
+		}
+	}
+	if err != nil {
+		result.TotalTime = elapsedMs(start, time.Now())
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return result, fmt.Errorf("upgrade rejected: %s", resp.Status)
+		}
+		return result, fmt.Errorf("dial error: %v", err)
+	}
+	defer conn.Close()
+	result.Subprotocol = conn.Subprotocol()
+	conn.SetReadLimit(wsProbeMaxMessageSize)
+
+	// Record the close frame of the server, whether it answers ours or closes first
+	conn.SetCloseHandler(func(code int, text string) error {
+		result.CloseCode, result.CloseText = code, text
+		if result.ClosedBy == "" {
+			result.ClosedBy = "server"
+		}
+		return nil
+	})
+
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return result, err
+	}
+	if options.Message != "" {
+		sent := time.Now()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(options.Message)); err != nil {
+			result.TotalTime = elapsedMs(start, time.Now())
+			return result, fmt.Errorf("send error: %v", err)
+		}
+		_, reply, err := conn.ReadMessage()
+		if err != nil {
+			result.TotalTime = elapsedMs(start, time.Now())
+			if result.ClosedBy == "server" {
+				return result, fmt.Errorf("server closed before replying: %d %s", result.CloseCode, result.CloseText)
+			}
+			return result, fmt.Errorf("receive error: %v", err)
+		}
+		result.ReplyTime = elapsedMs(sent, time.Now())
+		result.ReplySize = len(reply)
+		if len(reply) > wsProbeReplyPreview {
+			reply = reply[:wsProbeReplyPreview]
+		}
+		result.Reply = strings.ToValidUTF8(string(reply), "�")
+	}
+
+	// Closing handshake, the server should echo the close frame
+	result.ClosedBy = "client"
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := conn.WriteControl(websocket.CloseMessage, message, deadline); err != nil {
+		result.TotalTime = elapsedMs(start, time.Now())
+		return result, fmt.Errorf("close error: %v", err)
+	}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			result.TotalTime = elapsedMs(start, time.Now())
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				return result, fmt.Errorf("close error: no close frame received: %v", err)
+			}
+			return result, nil
+		}
+	}
+}
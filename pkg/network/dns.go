@@ -0,0 +1,347 @@
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// DNS transports, DoT follows RFC 7858 and DoH follows RFC 8484
+const (
+	DNSTransportUDP = "udp"
+	DNSTransportTCP = "tcp"
+	DNSTransportDoT = "dot"
+	DNSTransportDoH = "doh"
+)
+
+const (
+	dnsMessageType = "application/dns-message"
+	dohPath        = "/dns-query"
+	// dohMaxReply bounds a DoH body, a DNS message never exceeds 65535 bytes
+	dohMaxReply = 65535
+)
+
+// DNSTransports lists every transport in the order they are compared
+var DNSTransports = []string{DNSTransportUDP, DNSTransportTCP, DNSTransportDoT, DNSTransportDoH}
+
+// DNSResolver sends raw DNS queries to a single server
+type DNSResolver struct {
+	Server    string
+	Transport string
+	Timeout   time.Duration
+	// ServerName is verified against the DoT and DoH certificate, the server host by default
+	ServerName string
+	// InsecureSkipVerify disables DoT and DoH certificate verification
+	InsecureSkipVerify bool
+}
+
+// DNSResult holds a DNS reply together with how it was obtained
+type DNSResult struct {
+	Msg       *dns.Msg
+	Server    string
+	Transport string
+	// Rtt covers the query alone, ConnectTime and TLSTime the connection set up before it
+	Rtt         time.Duration
+	ConnectTime time.Duration
+	TLSTime     time.Duration
+	Total       time.Duration
+}
+
+// Exchange sends msg to the resolver and retries over TCP when a UDP reply is truncated
+func (r *DNSResolver) Exchange(ctx context.Context, msg *dns.Msg) (*DNSResult, error) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	transport := r.Transport
+	if transport == "" {
+		transport = DNSTransportUDP
+	}
+	server, err := DNSServerAddress(r.Server, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	var result *DNSResult
+	switch transport {
+	case DNSTransportUDP:
+		client := &dns.Client{Net: "udp", Timeout: timeout}
+		var reply *dns.Msg
+		var rtt time.Duration
+		reply, rtt, err = client.ExchangeContext(ctx, msg, server)
+		if err == nil && reply.Truncated {
+			log.Debugf("Truncated DNS reply from %s, retrying over TCP", server)
+			transport = DNSTransportTCP
+			result, err = r.exchangeStream(ctx, msg, server, transport, timeout)
+		} else if err == nil {
+			result = &DNSResult{Msg: reply, Rtt: rtt}
+		}
+	case DNSTransportTCP, DNSTransportDoT:
+		result, err = r.exchangeStream(ctx, msg, server, transport, timeout)
+	case DNSTransportDoH:
+		result, err = r.exchangeHTTPS(ctx, msg, server, timeout)
+	default:
+		return nil, fmt.Errorf("unsupported DNS transport: %s", transport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query %s over %s failed: %v", server, transport, err)
+	}
+	result.Server = server
+	result.Transport = transport
+	result.Total = time.Since(start)
+	return result, nil
+}
+
+// exchangeStream sends msg over a fresh TCP connection, wrapped in TLS for DoT
+func (r *DNSResolver) exchangeStream(ctx context.Context, msg *dns.Msg, server string, transport string, timeout time.Duration) (*DNSResult, error) {
+	result := &DNSResult{}
+	dialer := &net.Dialer{Timeout: timeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	result.ConnectTime = time.Since(start)
+
+	if transport == DNSTransportDoT {
+		host, _, _ := net.SplitHostPort(server)
+		tlsConn := tls.Client(conn, r.tlsConfig(host))
+		start = time.Now()
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		result.TLSTime = time.Since(start)
+		conn = tlsConn
+	}
+
+	client := &dns.Client{Net: "tcp", Timeout: timeout}
+	result.Msg, result.Rtt, err = client.ExchangeWithConnContext(ctx, msg, &dns.Conn{Conn: conn})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// exchangeHTTPS POSTs msg to a DoH endpoint over a connection of its own so the set up is timed
+func (r *DNSResolver) exchangeHTTPS(ctx context.Context, msg *dns.Msg, endpoint string, timeout time.Duration) (*DNSResult, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	// RFC 8484 section 4.1 recommends ID 0 so replies stay cacheable
+	query := msg.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DNSResult{}
+	var connectStart, tlsStart, wrote time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) { connectStart = time.Now() },
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				result.ConnectTime = time.Since(connectStart)
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				result.TLSTime = time.Since(tlsStart)
+			}
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) { wrote = time.Now() },
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodPost, endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	transport := &http.Transport{
+		DialContext:       (&net.Dialer{Timeout: timeout}).DialContext,
+		TLSClientConfig:   r.tlsConfig(u.Hostname()),
+		ForceAttemptHTTP2: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxReply))
+	if err != nil {
+		return nil, err
+	}
+	if !wrote.IsZero() {
+		result.Rtt = time.Since(wrote)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, dnsMessageType) {
+		return nil, fmt.Errorf("unexpected content type: %s", contentType)
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, err
+	}
+	reply.Id = msg.Id
+	result.Msg = reply
+	return result, nil
+}
+
+// tlsConfig verifies the server against ServerName, or host when ServerName is empty
+func (r *DNSResolver) tlsConfig(host string) *tls.Config {
+	serverName := r.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	return &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: r.InsecureSkipVerify,
+	}
+}
+
+// LookupIPAddr resolves host to its IPv4 and IPv6 addresses through the resolver
+func (r *DNSResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+
+	qtypes := []uint16{dns.TypeA, dns.TypeAAAA}
+	replies := make([]*DNSResult, len(qtypes))
+	errs := make([]error, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func(i int, qtype uint16) {
+			defer wg.Done()
+			msg := new(dns.Msg)
+			msg.SetQuestion(dns.Fqdn(host), qtype)
+			replies[i], errs[i] = r.Exchange(ctx, msg)
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	var ips []net.IPAddr
+	for _, reply := range replies {
+		if reply == nil {
+			continue
+		}
+		for _, rr := range reply.Msg.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				ips = append(ips, net.IPAddr{IP: rr.A})
+			case *dns.AAAA:
+				ips = append(ips, net.IPAddr{IP: rr.AAAA})
+			}
+		}
+	}
+	if len(ips) == 0 {
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("no such host: %s", host)
+	}
+	return ips, nil
+}
+
+// ConfiguredDNSResolver builds the resolver set by dns.server and dns.transport, nil when no server is set
+func ConfiguredDNSResolver() *DNSResolver {
+	server := viper.GetString("dns.server")
+	if server == "" {
+		return nil
+	}
+	transport := viper.GetString("dns.transport")
+	if transport == "" {
+		transport = DNSTransportUDP
+	}
+	if !slices.Contains(DNSTransports, transport) {
+		log.Warnf("Unsupported dns.transport %s, using the system resolver", transport)
+		return nil
+	}
+	if _, err := DNSServerAddress(server, transport); err != nil {
+		log.Warnf("Invalid dns.server %s, using the system resolver: %v", server, err)
+		return nil
+	}
+	return &DNSResolver{Server: server, Transport: transport}
+}
+
+// DNSServerAddress formats a resolver for the transport, a bare host gets the default port or DoH path
+func DNSServerAddress(server string, transport string) (string, error) {
+	server = strings.TrimSpace(server)
+	if transport == DNSTransportDoH {
+		if !strings.Contains(server, "://") {
+			server = "https://" + server + dohPath
+		}
+		u, err := url.Parse(server)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return "", fmt.Errorf("invalid DoH endpoint: %s", server)
+		}
+		return server, nil
+	}
+
+	if u, err := url.Parse(server); err == nil && u.Scheme != "" && u.Host != "" {
+		server = u.Host
+	}
+	port := "53"
+	if transport == DNSTransportDoT {
+		port = "853"
+	}
+	server = NormalizeDNSServer(server, port)
+	if host, _, err := net.SplitHostPort(server); err != nil || host == "" {
+		return "", fmt.Errorf("invalid resolver address: %s", server)
+	}
+	return server, nil
+}
+
+// DNSServerHost strips the port and DoH path from a resolver address
+func DNSServerHost(server string) string {
+	server = strings.TrimSpace(server)
+	if u, err := url.Parse(server); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Hostname()
+	}
+	if host, _, err := net.SplitHostPort(server); err == nil {
+		return host
+	}
+	return strings.Trim(server, "[]")
+}
+
+// NormalizeDNSServer appends the default port to a resolver address when it has none
+func NormalizeDNSServer(server string, defaultPort string) string {
+	server = strings.TrimSpace(server)
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), defaultPort)
+}
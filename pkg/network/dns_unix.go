@@ -0,0 +1,29 @@
+//go:build !windows
+
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+const resolvConfPath = "/etc/resolv.conf"
+
+// SystemNameservers returns the resolvers configured for this host
+func SystemNameservers() []string {
+	config, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		log.Debugf("Read %s failed: %v", resolvConfPath, err)
+		return nil
+	}
+	servers := make([]string, 0, len(config.Servers))
+	for _, server := range config.Servers {
+		servers = append(servers, net.JoinHostPort(server, config.Port))
+	}
+	return servers
+}
@@ -0,0 +1,54 @@
+//go:build windows
+
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// SystemNameservers returns the DNS servers of the network adapters that are up
+func SystemNameservers() []string {
+	flags := uint32(windows.GAA_FLAG_SKIP_UNICAST | windows.GAA_FLAG_SKIP_ANYCAST | windows.GAA_FLAG_SKIP_MULTICAST | windows.GAA_FLAG_SKIP_FRIENDLY_NAME)
+	// The required size is only known after a first attempt
+	size := uint32(15000)
+	var buf []byte
+	for {
+		buf = make([]byte, size)
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, flags, 0, (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])), &size)
+		if err == nil {
+			break
+		}
+		if err != windows.ERROR_BUFFER_OVERFLOW || size <= uint32(len(buf)) {
+			log.Debugf("GetAdaptersAddresses failed: %v", err)
+			return nil
+		}
+	}
+
+	seen := make(map[string]bool)
+	var servers []string
+	for aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); aa != nil; aa = aa.Next {
+		if aa.OperStatus != windows.IfOperStatusUp {
+			continue
+		}
+		for ds := aa.FirstDnsServerAddress; ds != nil; ds = ds.Next {
+			ip := ds.Address.IP()
+			// Windows lists deprecated site-local fec0::/10 servers on adapters without IPv6 DNS
+			if ip == nil || (ip.To4() == nil && ip[0] == 0xfe && ip[1]&0xc0 == 0xc0) {
+				continue
+			}
+			server := net.JoinHostPort(ip.String(), "53")
+			if !seen[server] {
+				seen[server] = true
+				servers = append(servers, server)
+			}
+		}
+	}
+	return servers
+}
@@ -0,0 +1,756 @@
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSSEC validation states as defined in RFC 4033 section 5
+const (
+	DNSSECSecure        = "secure"
+	DNSSECInsecure      = "insecure"
+	DNSSECBogus         = "bogus"
+	DNSSECIndeterminate = "indeterminate"
+)
+
+// RootTrustAnchors are the DS records of the root zone KSKs published by IANA
+var RootTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// dnssecRank orders the states so the worst one found decides the result
+var dnssecRank = map[string]int{
+	DNSSECSecure:        0,
+	DNSSECInsecure:      1,
+	DNSSECIndeterminate: 2,
+	DNSSECBogus:         3,
+}
+
+// DNSSECValidator walks the chain of trust from the root trust anchors down to an answer.
+// Queries go through Resolver with the CD bit set so bogus data is returned and can be reported.
+type DNSSECValidator struct {
+	Resolver     *DNSResolver
+	TrustAnchors []*dns.DS
+}
+
+// DNSSECResult is the outcome of a validation together with everything checked on the way
+type DNSSECResult struct {
+	Status     string
+	Reason     string
+	Zones      []DNSSECZone
+	Signatures []DNSSECSignature
+	Mismatches []DNSSECMismatch
+}
+
+// DNSSECZone is a zone of the chain and the keys it was found to use
+type DNSSECZone struct {
+	Name   string
+	Status string
+	DS     []*dns.DS
+	Keys   []*dns.DNSKEY
+}
+
+// DNSSECSignature is an RRSIG that was checked and whether it held
+type DNSSECSignature struct {
+	Owner      string
+	Covered    uint16
+	Signer     string
+	KeyTag     uint16
+	Algorithm  uint8
+	Inception  time.Time
+	Expiration time.Time
+	Valid      bool
+	Error      string
+}
+
+// DNSSECMismatch is a DS record that no DNSKEY of the child zone matches
+type DNSSECMismatch struct {
+	Zone       string
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Reason     string
+}
+
+// ParseTrustAnchors reads root DS records separated by newlines or semicolons
+func ParseTrustAnchors(text string) ([]*dns.DS, error) {
+	var anchors []*dns.DS
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ';' }) {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust anchor %q: %v", line, err)
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok || ds.Hdr.Name != "." {
+			return nil, fmt.Errorf("trust anchor is not a root DS record: %s", line)
+		}
+		anchors = append(anchors, ds)
+	}
+	if len(anchors) == 0 {
+		return nil, fmt.Errorf("no trust anchor configured")
+	}
+	return anchors, nil
+}
+
+// Validate queries name and checks the answer, or the denial of it, back to the trust anchors.
+// A denial from a secure zone holds only when its NSEC or NSEC3 records prove it for the name.
+func (v *DNSSECValidator) Validate(ctx context.Context, name string, qtype uint16) *DNSSECResult {
+	run := &dnssecRun{
+		v:       v,
+		ctx:     ctx,
+		now:     time.Now(),
+		result:  &DNSSECResult{Status: DNSSECSecure},
+		replies: make(map[string]*dns.Msg),
+		zones:   make(map[string]*DNSSECZone),
+	}
+
+	reply, err := run.query(dns.Fqdn(name), qtype)
+	if err != nil {
+		run.fail(DNSSECIndeterminate, err.Error())
+		return run.result
+	}
+
+	section := reply.Answer
+	if len(section) == 0 || reply.Rcode == dns.RcodeNameError {
+		section = reply.Ns
+	}
+	if len(section) == 0 {
+		run.fail(DNSSECIndeterminate, "reply has neither an answer nor a denial")
+		return run.result
+	}
+	for _, rrset := range splitRRsets(section) {
+		run.verifyAnswer(rrset)
+	}
+	if len(reply.Answer) == 0 || reply.Rcode == dns.RcodeNameError {
+		run.verifyDenial(reply, deniedName(dns.Fqdn(name), reply.Answer), qtype)
+	}
+	return run.result
+}
+
+// dnssecRun holds the state of a single validation
+type dnssecRun struct {
+	v       *DNSSECValidator
+	ctx     context.Context
+	now     time.Time
+	result  *DNSSECResult
+	replies map[string]*dns.Msg
+	zones   map[string]*DNSSECZone
+}
+
+// rrset is the records sharing owner and type together with their signatures
+type rrset struct {
+	owner string
+	rtype uint16
+	rrs   []dns.RR
+	sigs  []*dns.RRSIG
+}
+
+// fail lowers the result to status unless something worse was already found
+func (run *dnssecRun) fail(status string, reason string) {
+	if dnssecRank[status] > dnssecRank[run.result.Status] {
+		run.result.Status = status
+		run.result.Reason = reason
+	}
+}
+
+// query sends a DNSSEC OK query with checking disabled, replies are reused within the run
+func (run *dnssecRun) query(name string, qtype uint16) (*dns.Msg, error) {
+	key := name + "/" + dns.TypeToString[qtype]
+	if reply, ok := run.replies[key]; ok {
+		return reply, nil
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.SetEdns0(4096, true)
+	msg.CheckingDisabled = true
+	result, err := run.v.Resolver.Exchange(run.ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	if rcode := result.Msg.Rcode; rcode != dns.RcodeSuccess && rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("query %s %s returned %s", name, dns.TypeToString[qtype], dns.RcodeToString[rcode])
+	}
+	run.replies[key] = result.Msg
+	return result.Msg, nil
+}
+
+// verifyAnswer checks an answer or denial RRset against the keys of the zone that signed it
+func (run *dnssecRun) verifyAnswer(set *rrset) {
+	zone := ""
+	if len(set.sigs) > 0 {
+		zone = set.sigs[0].SignerName
+		// A signer outside the owner's ancestry must not pick which chain is walked
+		if !dns.IsSubDomain(zone, set.owner) {
+			run.fail(DNSSECBogus, fmt.Sprintf("%s is signed by %s which does not enclose it", set.owner, zone))
+			return
+		}
+	} else {
+		var err error
+		if zone, err = run.findZone(set.owner); err != nil {
+			run.fail(DNSSECIndeterminate, err.Error())
+			return
+		}
+	}
+
+	state := run.secureZone(zone)
+	if state.Status != DNSSECSecure {
+		return
+	}
+	if len(set.sigs) == 0 {
+		run.fail(DNSSECBogus, fmt.Sprintf("%s %s is not signed although %s is secure", set.owner, dns.TypeToString[set.rtype], zone))
+		return
+	}
+	if !run.verifyRRset(set, state.Keys) {
+		run.fail(DNSSECBogus, fmt.Sprintf("no valid signature for %s %s", set.owner, dns.TypeToString[set.rtype]))
+	}
+}
+
+// findZone returns the closest enclosing zone of name from the SOA record of a reply
+func (run *dnssecRun) findZone(name string) (string, error) {
+	reply, err := run.query(name, dns.TypeSOA)
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range append(reply.Answer, reply.Ns...) {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Hdr.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no SOA record found for %s", name)
+}
+
+// secureZone walks from the root to zone, authenticating each delegation with its DS records
+func (run *dnssecRun) secureZone(zone string) *DNSSECZone {
+	zone = dns.CanonicalName(zone)
+	if state, ok := run.zones[zone]; ok {
+		return state
+	}
+
+	parent := run.zoneKeys(".", run.v.TrustAnchors)
+	if parent.Status != DNSSECSecure || zone == "." {
+		return parent
+	}
+
+	labels := dns.SplitDomainName(zone)
+	for i := len(labels) - 1; i >= 0; i-- {
+		child := dns.Fqdn(strings.Join(labels[i:], "."))
+		if state, ok := run.zones[child]; ok {
+			if state.Status != DNSSECSecure {
+				return state
+			}
+			parent = state
+			continue
+		}
+
+		reply, err := run.query(child, dns.TypeDS)
+		if err != nil {
+			return run.settle(child, DNSSECIndeterminate, err.Error())
+		}
+
+		sets := splitRRsets(append(reply.Answer, reply.Ns...))
+		var dsSet *rrset
+		for _, set := range sets {
+			if set.rtype == dns.TypeDS && dns.CanonicalName(set.owner) == child {
+				dsSet = set
+			}
+		}
+		if dsSet != nil {
+			if !run.verifyRRset(dsSet, parent.Keys) {
+				return run.settle(child, DNSSECBogus, fmt.Sprintf("DS records of %s are not validly signed by %s", child, parent.Name))
+			}
+			dsList := make([]*dns.DS, 0, len(dsSet.rrs))
+			for _, rr := range dsSet.rrs {
+				dsList = append(dsList, rr.(*dns.DS))
+			}
+			state := run.zoneKeys(child, dsList)
+			if state.Status != DNSSECSecure {
+				return state
+			}
+			parent = state
+			continue
+		}
+
+		// Without DS the parent must prove the absence with signed NSEC or NSEC3 records
+		var nsecs []*dns.NSEC
+		var nsec3s []*dns.NSEC3
+		for _, set := range sets {
+			if set.rtype != dns.TypeNSEC && set.rtype != dns.TypeNSEC3 {
+				continue
+			}
+			if !run.verifyRRset(set, parent.Keys) {
+				return run.settle(child, DNSSECBogus, fmt.Sprintf("denial of DS for %s is not validly signed", child))
+			}
+			nsecs, nsec3s = appendDenialRecords(nsecs, nsec3s, set)
+		}
+		if len(nsecs) == 0 && len(nsec3s) == 0 {
+			return run.settle(child, DNSSECIndeterminate, fmt.Sprintf("%s returned no signed denial of DS for %s", parent.Name, child))
+		}
+
+		delegation, err := denyDS(child, nsecs, nsec3s)
+		if err != nil {
+			return run.settle(child, DNSSECBogus, fmt.Sprintf("denial of DS for %s does not hold: %v", child, err))
+		}
+		if delegation {
+			return run.settle(child, DNSSECInsecure, fmt.Sprintf("%s is delegated from %s without DS records", child, parent.Name))
+		}
+		// The proof shows child is no zone cut, which contradicts it signing the data
+		if child == zone {
+			return run.settle(child, DNSSECBogus, fmt.Sprintf("%s proves %s is not delegated", parent.Name, child))
+		}
+	}
+	return parent
+}
+
+// verifyDenial checks that a negative reply from a secure zone proves the absence of name and qtype
+func (run *dnssecRun) verifyDenial(reply *dns.Msg, name string, qtype uint16) {
+	zone := ""
+	for _, rr := range reply.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			zone = soa.Hdr.Name
+		}
+	}
+	if zone == "" {
+		var err error
+		if zone, err = run.findZone(name); err != nil {
+			run.fail(DNSSECIndeterminate, err.Error())
+			return
+		}
+	}
+	zone = dns.CanonicalName(zone)
+	if !dns.IsSubDomain(zone, name) {
+		run.fail(DNSSECBogus, fmt.Sprintf("denial of %s comes from %s which does not enclose it", name, zone))
+		return
+	}
+	if run.secureZone(zone).Status != DNSSECSecure {
+		return
+	}
+
+	// Only records signed by the zone itself were checked against its keys
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	for _, set := range splitRRsets(reply.Ns) {
+		if len(set.sigs) > 0 && dns.CanonicalName(set.sigs[0].SignerName) == zone {
+			nsecs, nsec3s = appendDenialRecords(nsecs, nsec3s, set)
+		}
+	}
+	if len(nsecs) == 0 && len(nsec3s) == 0 {
+		run.fail(DNSSECIndeterminate, fmt.Sprintf("%s returned no signed NSEC or NSEC3 records to deny %s %s", zone, name, dns.TypeToString[qtype]))
+		return
+	}
+
+	var err error
+	if reply.Rcode == dns.RcodeNameError {
+		err = denyName(name, nsecs, nsec3s)
+	} else {
+		err = denyType(name, qtype, nsecs, nsec3s)
+	}
+	if err != nil {
+		run.fail(DNSSECBogus, fmt.Sprintf("denial of %s %s does not hold: %v", name, dns.TypeToString[qtype], err))
+	}
+}
+
+// zoneKeys fetches the DNSKEY records of zone and authenticates them with the DS records vouching for it
+func (run *dnssecRun) zoneKeys(zone string, dsList []*dns.DS) *DNSSECZone {
+	if state, ok := run.zones[zone]; ok {
+		return state
+	}
+
+	reply, err := run.query(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return run.settle(zone, DNSSECIndeterminate, err.Error())
+	}
+	var keySet *rrset
+	for _, set := range splitRRsets(reply.Answer) {
+		if set.rtype == dns.TypeDNSKEY && dns.CanonicalName(set.owner) == zone {
+			keySet = set
+		}
+	}
+	if keySet == nil {
+		return run.settle(zone, DNSSECBogus, fmt.Sprintf("%s has DS records but no DNSKEY", zone))
+	}
+	keys := make([]*dns.DNSKEY, 0, len(keySet.rrs))
+	for _, rr := range keySet.rrs {
+		keys = append(keys, rr.(*dns.DNSKEY))
+	}
+
+	var matched []*dns.DNSKEY
+	for _, ds := range dsList {
+		var key *dns.DNSKEY
+		for _, k := range keys {
+			if k.KeyTag() == ds.KeyTag && k.Algorithm == ds.Algorithm {
+				key = k
+				break
+			}
+		}
+		mismatch := DNSSECMismatch{Zone: zone, KeyTag: ds.KeyTag, Algorithm: ds.Algorithm, DigestType: ds.DigestType}
+		switch digest := digestOf(key, ds.DigestType); {
+		case key == nil:
+			mismatch.Reason = "no DNSKEY with this key tag and algorithm"
+		case digest == "":
+			mismatch.Reason = "unsupported digest type"
+		case !strings.EqualFold(digest, ds.Digest):
+			mismatch.Reason = "DNSKEY digest does not match"
+		default:
+			matched = append(matched, key)
+			continue
+		}
+		run.result.Mismatches = append(run.result.Mismatches, mismatch)
+	}
+
+	state := &DNSSECZone{Name: zone, DS: dsList, Keys: keys}
+	if len(matched) == 0 {
+		return run.settle(zone, DNSSECBogus, fmt.Sprintf("no DNSKEY of %s matches its DS records", zone), state)
+	}
+	if !run.verifyRRset(keySet, matched) {
+		return run.settle(zone, DNSSECBogus, fmt.Sprintf("DNSKEY set of %s is not validly signed by a key its DS records vouch for", zone), state)
+	}
+	return run.settle(zone, DNSSECSecure, "", state)
+}
+
+// settle records the state of a zone in the chain, and in the result unless it is secure
+func (run *dnssecRun) settle(zone string, status string, reason string, states ...*DNSSECZone) *DNSSECZone {
+	state := &DNSSECZone{Name: zone}
+	if len(states) > 0 {
+		state = states[0]
+	}
+	state.Status = status
+	run.zones[zone] = state
+	run.result.Zones = append(run.result.Zones, *state)
+	if status != DNSSECSecure {
+		run.fail(status, reason)
+	}
+	return state
+}
+
+// verifyRRset records every signature over set and reports whether one of keys produced a valid one
+func (run *dnssecRun) verifyRRset(set *rrset, keys []*dns.DNSKEY) bool {
+	valid := false
+	for _, sig := range set.sigs {
+		signature := DNSSECSignature{
+			Owner:      set.owner,
+			Covered:    sig.TypeCovered,
+			Signer:     sig.SignerName,
+			KeyTag:     sig.KeyTag,
+			Algorithm:  sig.Algorithm,
+			Inception:  time.Unix(int64(sig.Inception), 0).UTC(),
+			Expiration: time.Unix(int64(sig.Expiration), 0).UTC(),
+		}
+		var key *dns.DNSKEY
+		for _, k := range keys {
+			if k.KeyTag() == sig.KeyTag && k.Algorithm == sig.Algorithm {
+				key = k
+				break
+			}
+		}
+		switch {
+		case key == nil:
+			signature.Error = "signing key is not trusted"
+		case !sig.ValidityPeriod(run.now):
+			if run.now.Before(signature.Inception) {
+				signature.Error = "signature is not yet valid"
+			} else {
+				signature.Error = "signature has expired"
+			}
+		default:
+			if err := sig.Verify(key, set.rrs); err != nil {
+				signature.Error = err.Error()
+			} else {
+				signature.Valid = true
+				valid = true
+			}
+		}
+		run.result.Signatures = append(run.result.Signatures, signature)
+	}
+	return valid
+}
+
+// digestOf computes the DS digest of key, empty for a missing key or an unsupported digest type
+func digestOf(key *dns.DNSKEY, digestType uint8) string {
+	if key == nil {
+		return ""
+	}
+	ds := key.ToDS(digestType)
+	if ds == nil {
+		return ""
+	}
+	return ds.Digest
+}
+
+// splitRRsets groups records by owner and type and attaches the RRSIGs covering each group
+func splitRRsets(rrs []dns.RR) []*rrset {
+	var sets []*rrset
+	index := make(map[string]*rrset)
+	for _, rr := range rrs {
+		header := rr.Header()
+		if header.Rrtype == dns.TypeOPT || header.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		key := dns.CanonicalName(header.Name) + "/" + dns.TypeToString[header.Rrtype]
+		set := index[key]
+		if set == nil {
+			set = &rrset{owner: header.Name, rtype: header.Rrtype}
+			index[key] = set
+			sets = append(sets, set)
+		}
+		set.rrs = append(set.rrs, rr)
+	}
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if set := index[dns.CanonicalName(sig.Hdr.Name)+"/"+dns.TypeToString[sig.TypeCovered]]; set != nil {
+				set.sigs = append(set.sigs, sig)
+			}
+		}
+	}
+	return sets
+}
+
+// deniedName follows the CNAME chain of an answer to the name the denial is about
+func deniedName(name string, answer []dns.RR) string {
+	for i := 0; i < len(answer); i++ {
+		for _, rr := range answer {
+			if cname, ok := rr.(*dns.CNAME); ok && dns.CanonicalName(cname.Hdr.Name) == dns.CanonicalName(name) {
+				name = cname.Target
+				break
+			}
+		}
+	}
+	return dns.CanonicalName(name)
+}
+
+// appendDenialRecords adds the NSEC and NSEC3 records of set
+func appendDenialRecords(nsecs []*dns.NSEC, nsec3s []*dns.NSEC3, set *rrset) ([]*dns.NSEC, []*dns.NSEC3) {
+	for _, rr := range set.rrs {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, rr)
+		case *dns.NSEC3:
+			nsec3s = append(nsec3s, rr)
+		}
+	}
+	return nsecs, nsec3s
+}
+
+// denyDS proves that child has no DS record (RFC 4035 section 5.2, RFC 5155 section 8.9)
+// and reports whether child is a delegation, which then makes it an insecure zone.
+func denyDS(child string, nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) (bool, error) {
+	for _, nsec := range nsecs {
+		if dns.CanonicalName(nsec.Hdr.Name) != child {
+			continue
+		}
+		if hasType(nsec.TypeBitMap, dns.TypeDS) {
+			return false, fmt.Errorf("NSEC of %s lists a DS record", child)
+		}
+		if hasType(nsec.TypeBitMap, dns.TypeSOA) {
+			return false, fmt.Errorf("NSEC of %s is from the child zone", child)
+		}
+		return hasType(nsec.TypeBitMap, dns.TypeNS), nil
+	}
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, child) {
+			return false, nil
+		}
+	}
+
+	for _, nsec3 := range nsec3s {
+		if !nsec3.Match(child) {
+			continue
+		}
+		if hasType(nsec3.TypeBitMap, dns.TypeDS) {
+			return false, fmt.Errorf("NSEC3 of %s lists a DS record", child)
+		}
+		if hasType(nsec3.TypeBitMap, dns.TypeSOA) {
+			return false, fmt.Errorf("NSEC3 of %s is from the child zone", child)
+		}
+		return hasType(nsec3.TypeBitMap, dns.TypeNS), nil
+	}
+	if len(nsec3s) > 0 {
+		_, cover, err := closestEncloser(child, nsec3s)
+		if err != nil {
+			return false, err
+		}
+		// An opt-out span may hold unsigned delegations
+		return cover.Flags&nsec3OptOut != 0, nil
+	}
+	return false, fmt.Errorf("no NSEC or NSEC3 record matches or covers %s", child)
+}
+
+// denyName proves that name does not exist and that no wildcard could have answered for it
+func denyName(name string, nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) error {
+	for _, nsec := range nsecs {
+		if !nsecCovers(nsec, name) {
+			continue
+		}
+		wildcard := "*." + nsecEncloser(nsec, name)
+		for _, w := range nsecs {
+			if nsecCovers(w, wildcard) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no NSEC record covers the wildcard %s", wildcard)
+	}
+	if len(nsec3s) > 0 {
+		encloser, _, err := closestEncloser(name, nsec3s)
+		if err != nil {
+			return err
+		}
+		wildcard := "*." + encloser
+		for _, nsec3 := range nsec3s {
+			if nsec3.Cover(wildcard) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no NSEC3 record covers the wildcard %s", wildcard)
+	}
+	return fmt.Errorf("no NSEC record covers %s", name)
+}
+
+// denyType proves that name exists without qtype, directly or through a wildcard
+func denyType(name string, qtype uint16, nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) error {
+	lacks := func(bitmap []uint16) error {
+		if hasType(bitmap, qtype) || hasType(bitmap, dns.TypeCNAME) {
+			return fmt.Errorf("the type bitmap lists %s or CNAME", dns.TypeToString[qtype])
+		}
+		return nil
+	}
+
+	for _, nsec := range nsecs {
+		if dns.CanonicalName(nsec.Hdr.Name) == name {
+			return lacks(nsec.TypeBitMap)
+		}
+	}
+	for _, nsec := range nsecs {
+		if !nsecCovers(nsec, name) {
+			continue
+		}
+		wildcard := "*." + nsecEncloser(nsec, name)
+		for _, w := range nsecs {
+			if dns.CanonicalName(w.Hdr.Name) == wildcard {
+				return lacks(w.TypeBitMap)
+			}
+		}
+		return fmt.Errorf("no NSEC record matches the wildcard %s", wildcard)
+	}
+
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) {
+			return lacks(nsec3.TypeBitMap)
+		}
+	}
+	if len(nsec3s) > 0 {
+		encloser, _, err := closestEncloser(name, nsec3s)
+		if err != nil {
+			return err
+		}
+		wildcard := "*." + encloser
+		for _, nsec3 := range nsec3s {
+			if nsec3.Match(wildcard) {
+				return lacks(nsec3.TypeBitMap)
+			}
+		}
+		return fmt.Errorf("no NSEC3 record matches the wildcard %s", wildcard)
+	}
+	return fmt.Errorf("no NSEC record matches or covers %s", name)
+}
+
+// nsec3OptOut is the opt-out flag of an NSEC3 record (RFC 5155 section 3.1.2)
+const nsec3OptOut = 0x01
+
+// closestEncloser finds the closest encloser of name and the NSEC3 record covering the next closer name
+// (RFC 5155 section 8.3)
+func closestEncloser(name string, nsec3s []*dns.NSEC3) (string, *dns.NSEC3, error) {
+	labels := dns.SplitDomainName(name)
+	for i := 1; i <= len(labels); i++ {
+		encloser := dns.Fqdn(strings.Join(labels[i:], "."))
+		matched := false
+		for _, nsec3 := range nsec3s {
+			if nsec3.Match(encloser) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		nextCloser := dns.Fqdn(strings.Join(labels[i-1:], "."))
+		for _, nsec3 := range nsec3s {
+			if nsec3.Cover(nextCloser) {
+				return dns.CanonicalName(encloser), nsec3, nil
+			}
+		}
+		return "", nil, fmt.Errorf("no NSEC3 record covers the next closer name %s", nextCloser)
+	}
+	return "", nil, fmt.Errorf("no NSEC3 record proves a closest encloser of %s", name)
+}
+
+// nsecCovers reports whether name falls strictly between the owner and next name of nsec.
+// Names below a delegation point are not covered, the parent is not authoritative for them.
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner := dns.CanonicalName(nsec.Hdr.Name)
+	if hasType(nsec.TypeBitMap, dns.TypeNS) && !hasType(nsec.TypeBitMap, dns.TypeSOA) && dns.IsSubDomain(owner, name) {
+		return false
+	}
+	next := dns.CanonicalName(nsec.NextDomain)
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+	// The last NSEC of a zone points back to the apex
+	return dns.IsSubDomain(next, name) && (canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0)
+}
+
+// nsecEncloser returns the closest encloser of a name covered by nsec, the deepest
+// ancestor of name that also encloses the owner or next name
+func nsecEncloser(nsec *dns.NSEC, name string) string {
+	n := max(dns.CompareDomainName(name, nsec.Hdr.Name), dns.CompareDomainName(name, nsec.NextDomain))
+	labels := dns.SplitDomainName(name)
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+// canonicalCompare orders domain names as RFC 4034 section 6.1 defines, label by label from the right
+func canonicalCompare(a, b string) int {
+	la, lb := canonicalLabels(a), canonicalLabels(b)
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if c := strings.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// canonicalLabels returns the lowercased wire format labels of name, so escapes compare as the octets they stand for
+func canonicalLabels(name string) []string {
+	buf := make([]byte, 256)
+	n, err := dns.PackDomainName(dns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		return dns.SplitDomainName(dns.CanonicalName(name))
+	}
+	var labels []string
+	for i := 0; i < n && buf[i] != 0; i += int(buf[i]) + 1 {
+		label := buf[i+1 : i+1+int(buf[i])]
+		for j, c := range label {
+			if c >= 'A' && c <= 'Z' {
+				label[j] = c + 'a' - 'A'
+			}
+		}
+		labels = append(labels, string(label))
+	}
+	return labels
+}
+
+// hasType reports whether a type bitmap lists rtype
+func hasType(bitmap []uint16, rtype uint16) bool {
+	return slices.Contains(bitmap, rtype)
+}
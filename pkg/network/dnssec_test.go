@@ -0,0 +1,237 @@
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Pre-signed fixtures with ECDSA P-256 keys, valid from 2024 to 2099.
+// The root signs example. and nsec3., example. denies with NSEC and delegates child.example. unsigned,
+// nsec3. denies with opt-out NSEC3 records and delegates deleg.nsec3. unsigned.
+var (
+	rootAnchor = ". 3600 IN DS 25280 13 2 E657E0E9968520974747A8AC370F496087A8C9817B8B5CBF51AA118C63DDE1D6"
+	rootDNSKEY = []string{
+		". 3600 IN DNSKEY 257 3 13 CJNUhQZvJrRB3WQ6PW28AKzpfYIKubxTH/u+GTjQA9CiVxAw4Sd65bcisQXjkIxMxu1O5BxKCfbOE/ZGiKm5EQ==",
+		". 3600 IN RRSIG DNSKEY 13 0 3600 20991231000000 20240101000000 25280 . cXWHkX570xEH7KeJt2QWaVzkyEIlazUKuIybOwrkS23CK3y8Shp+1Mbw2qGsNbWhaWYI8vnlOzRPG1imK9Jb4A==",
+	}
+	exampleDS = []string{
+		"example. 3600 IN DS 15591 13 2 60F08209D6C08AF71FCF71C3A28609BF9463E3C22DE186DCA35935B6A867466F",
+		"example. 3600 IN RRSIG DS 13 1 3600 20991231000000 20240101000000 25280 . xihlrqA/jUm/etvWq1ruSl1lgYuvsYiWuu+u0ktT2uBPba5WPScHbF0kez3ul9Ym2ske8TlvNBx7UILvxuGteQ==",
+	}
+	nsec3DS = []string{
+		"nsec3. 3600 IN DS 30019 13 2 7CE61117B3060337D75FCD701F3FE2A47A5C82D446A8FE1214E2E3392A02313B",
+		"nsec3. 3600 IN RRSIG DS 13 1 3600 20991231000000 20240101000000 25280 . C/iOhRIz/P8L1lpR9wOQNNxu34fBRTUvhAmkFr53XWLDfAetj/Mx9i7jLuZF0lkdzapSV2eW7ozmIgK/5QTYyw==",
+	}
+	exampleDNSKEY = []string{
+		"example. 3600 IN DNSKEY 257 3 13 IlaRo7PGioF4hcKc0x2WA+L2O4X83Wu9q1v+7IFVNGuKpZIm7VeqVbKEyq5q9q02CWCUxsLnACRqBXWWEEogQA==",
+		"example. 3600 IN RRSIG DNSKEY 13 1 3600 20991231000000 20240101000000 15591 example. yUFPk4//0Xvgos3duWopwloSLiiO0eSzaPD2QUkXa/R1Zvby5FpOB5qVxFcNFLKS8Z+YzRqspuTspFGZiJt05g==",
+	}
+	exampleSOA = []string{
+		"example. 3600 IN SOA ns.example. hostmaster.example. 1 7200 3600 1209600 3600",
+		"example. 3600 IN RRSIG SOA 13 1 3600 20991231000000 20240101000000 15591 example. at0DipncifX1Z/vn1vE3zslyFCCAlDNkiAkjVOvuvIzGyaw08g0+kZTvlYs2KM6qhlZtISs1HLsra5JuuKkbWw==",
+	}
+	wwwA = []string{
+		"www.example. 3600 IN A 192.0.2.1",
+		"www.example. 3600 IN RRSIG A 13 2 3600 20991231000000 20240101000000 15591 example. FSi6omLtcUstpN3wT5P+BAR+23LUSxUqcrcRmNuVFNE6NAaNZBErD2QuVlXOVfC+LPFMcTaLKA4/R9W/Ugo88w==",
+	}
+	exampleNSEC = []string{
+		"example. 3600 IN NSEC a.example. NS SOA RRSIG NSEC DNSKEY",
+		"example. 3600 IN RRSIG NSEC 13 1 3600 20991231000000 20240101000000 15591 example. KqsuYNy5GQbN4uBsB3lDzINJNVJ0APmoH7zx8wIKXefNVj+JnubapBaowAtFJJPAR3Fqw0m6hR3H8LVFCFDojQ==",
+	}
+	aNSEC = []string{
+		"a.example. 3600 IN NSEC child.example. A RRSIG NSEC",
+		"a.example. 3600 IN RRSIG NSEC 13 2 3600 20991231000000 20240101000000 15591 example. 7XONAXxIck7EnBNS9wqgL9y6PCFO+78lTjK02Gy0+So1o4SHrD24HJwC9SnGsy3HJ7yIW2ndj5BiJx4fd9ql3g==",
+	}
+	childNSEC = []string{
+		"child.example. 3600 IN NSEC www.example. NS RRSIG NSEC",
+		"child.example. 3600 IN RRSIG NSEC 13 2 3600 20991231000000 20240101000000 15591 example. zcR3MM0/BweO6r85QB/GfRIQf9exTdhf3zYjrs3yG2GTi/A8MSaIxqt2SHQgCBnhzhNClBv1bJAm93jhL4N4/Q==",
+	}
+	wwwNSEC = []string{
+		"www.example. 3600 IN NSEC example. A RRSIG NSEC",
+		"www.example. 3600 IN RRSIG NSEC 13 2 3600 20991231000000 20240101000000 15591 example. KwbqPrktum11rsYikZjEo6NYYdl4AFNWrF5sldT/KzQdCF0+peekku6QsrNTklvkPjSAA2izwsGdWGuenDlcOg==",
+	}
+	nsec3DNSKEY = []string{
+		"nsec3. 3600 IN DNSKEY 257 3 13 CKPe5VBOE/ucyqIbDwsRCH+DSYpu4V/sdqNhfOrsllzGByhkXWn0rNKotIH28ynaSKKY9zDQfxt01dmJ/2oNWg==",
+		"nsec3. 3600 IN RRSIG DNSKEY 13 1 3600 20991231000000 20240101000000 30019 nsec3. oPaDwsEQwmCPkscSb17F7bCkn4eyZSsTqM7wPId3T514OfCUxLTsjuVxEuoa0hpxpQGoqXGvjRj75rKS8ShorQ==",
+	}
+	nsec3SOA = []string{
+		"nsec3. 3600 IN SOA ns.nsec3. hostmaster.nsec3. 1 7200 3600 1209600 3600",
+		"nsec3. 3600 IN RRSIG SOA 13 1 3600 20991231000000 20240101000000 30019 nsec3. GIrcwjnEXJFjRKaAcdAhP/qo166adw8fO7RvOMtqLxh+lQ434kBweUqPCcCERmEp0AdMKty8QdZBsWJoNW/bWw==",
+	}
+	nsec3Apex = []string{
+		"3rrjesemtuh627kgk4qgon0250128tou.nsec3. 3600 IN NSEC3 1 1 0 - 7OVIES7N5D40NOV2FQUFALLMH79KQJMQ NS SOA RRSIG DNSKEY NSEC3PARAM",
+		"3rrjesemtuh627kgk4qgon0250128tou.nsec3. 3600 IN RRSIG NSEC3 13 2 3600 20991231000000 20240101000000 30019 nsec3. FWlivbZrVZG9eUgj/JAeEUw/CLlyK71EVAwP3KadrlpT+WZdcSh/1rJimq4+Vmqj6pI+d1B1AJhFJLWz7KyK3w==",
+	}
+	nsec3Host = []string{
+		"7ovies7n5d40nov2fqufallmh79kqjmq.nsec3. 3600 IN NSEC3 1 1 0 - 3RRJESEMTUH627KGK4QGON0250128TOU A RRSIG",
+		"7ovies7n5d40nov2fqufallmh79kqjmq.nsec3. 3600 IN RRSIG NSEC3 13 2 3600 20991231000000 20240101000000 30019 nsec3. aLha50oftkQqM/ElHRszbehnbRD2fA/CnNJ4fpJL4OQfDYnyWN9X8eiOWdgriUDSwkA0ZCXfEEWyIUuFCpBHrQ==",
+	}
+)
+
+// dnssecTestReply is the canned reply to one question
+type dnssecTestReply struct {
+	rcode  int
+	answer []string
+	ns     []string
+}
+
+// dnssecTestReplies answers every question of a walk that succeeds
+func dnssecTestReplies() map[string]dnssecTestReply {
+	return map[string]dnssecTestReply{
+		"./DNSKEY":              {answer: rootDNSKEY},
+		"example./DS":           {answer: exampleDS},
+		"example./DNSKEY":       {answer: exampleDNSKEY},
+		"nsec3./DS":             {answer: nsec3DS},
+		"nsec3./DNSKEY":         {answer: nsec3DNSKEY},
+		"www.example./A":        {answer: wwwA},
+		"www.example./AAAA":     {ns: slices.Concat(exampleSOA, wwwNSEC)},
+		"nope.example./A":       {rcode: dns.RcodeNameError, ns: slices.Concat(exampleSOA, childNSEC, exampleNSEC)},
+		"child.example./DS":     {ns: slices.Concat(exampleSOA, childNSEC)},
+		"host.child.example./A": {answer: []string{"host.child.example. 3600 IN A 192.0.2.2"}},
+		"host.child.example./SOA": {ns: []string{
+			"child.example. 3600 IN SOA ns.child.example. hostmaster.child.example. 1 7200 3600 1209600 3600",
+		}},
+		"missing.nsec3./A":   {rcode: dns.RcodeNameError, ns: slices.Concat(nsec3SOA, nsec3Apex, nsec3Host)},
+		"host.nsec3./AAAA":   {ns: slices.Concat(nsec3SOA, nsec3Host)},
+		"deleg.nsec3./DS":    {ns: slices.Concat(nsec3SOA, nsec3Apex, nsec3Host)},
+		"www.deleg.nsec3./A": {answer: []string{"www.deleg.nsec3. 3600 IN A 192.0.2.3"}},
+		"www.deleg.nsec3./SOA": {ns: []string{
+			"deleg.nsec3. 3600 IN SOA ns.deleg.nsec3. hostmaster.deleg.nsec3. 1 7200 3600 1209600 3600",
+		}},
+	}
+}
+
+// startDNSSECTestServer serves replies over UDP on the loopback and returns its address
+func startDNSSECTestServer(t *testing.T, replies map[string]dnssecTestReply) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	parse := func(lines []string) []dns.RR {
+		rrs := make([]dns.RR, 0, len(lines))
+		for _, line := range lines {
+			rr, err := dns.NewRR(line)
+			if err != nil {
+				t.Fatalf("fixture %q: %v", line, err)
+			}
+			rrs = append(rrs, rr)
+		}
+		return rrs
+	}
+
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		q := req.Question[0]
+		reply, ok := replies[dns.CanonicalName(q.Name)+"/"+dns.TypeToString[q.Qtype]]
+		if !ok {
+			msg.SetRcode(req, dns.RcodeRefused)
+		} else {
+			msg.SetRcode(req, reply.rcode)
+			msg.Answer = parse(reply.answer)
+			msg.Ns = parse(reply.ns)
+		}
+		_ = w.WriteMsg(msg)
+	})}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+	return conn.LocalAddr().String()
+}
+
+func TestDNSSECValidate(t *testing.T) {
+	anchor, err := dns.NewRR(rootAnchor)
+	if err != nil {
+		t.Fatalf("trust anchor: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		qname    string
+		qtype    uint16
+		override map[string]dnssecTestReply
+		want     string
+	}{
+		{name: "signed answer", qname: "www.example.", qtype: dns.TypeA, want: DNSSECSecure},
+		{name: "NSEC proves unsigned delegation", qname: "host.child.example.", qtype: dns.TypeA, want: DNSSECInsecure},
+		{
+			name: "replayed NSEC for DS", qname: "host.child.example.", qtype: dns.TypeA, want: DNSSECBogus,
+			override: map[string]dnssecTestReply{"child.example./DS": {ns: slices.Concat(exampleSOA, aNSEC)}},
+		},
+		{
+			name: "missing denial of DS", qname: "host.child.example.", qtype: dns.TypeA, want: DNSSECIndeterminate,
+			override: map[string]dnssecTestReply{"child.example./DS": {ns: exampleSOA}},
+		},
+		{name: "NSEC proves NXDOMAIN", qname: "nope.example.", qtype: dns.TypeA, want: DNSSECSecure},
+		{
+			name: "replayed NSEC for NXDOMAIN", qname: "nope.example.", qtype: dns.TypeA, want: DNSSECBogus,
+			override: map[string]dnssecTestReply{"nope.example./A": {rcode: dns.RcodeNameError, ns: slices.Concat(exampleSOA, wwwNSEC, exampleNSEC)}},
+		},
+		{
+			name: "NXDOMAIN without wildcard denial", qname: "nope.example.", qtype: dns.TypeA, want: DNSSECBogus,
+			override: map[string]dnssecTestReply{"nope.example./A": {rcode: dns.RcodeNameError, ns: slices.Concat(exampleSOA, childNSEC)}},
+		},
+		{name: "NSEC proves NODATA", qname: "www.example.", qtype: dns.TypeAAAA, want: DNSSECSecure},
+		{
+			name: "NODATA for a listed type", qname: "www.example.", qtype: dns.TypeA, want: DNSSECBogus,
+			override: map[string]dnssecTestReply{"www.example./A": {ns: slices.Concat(exampleSOA, wwwNSEC)}},
+		},
+		{
+			name: "replayed NSEC for NODATA", qname: "www.example.", qtype: dns.TypeAAAA, want: DNSSECBogus,
+			override: map[string]dnssecTestReply{"www.example./AAAA": {ns: slices.Concat(exampleSOA, aNSEC)}},
+		},
+		{
+			name: "missing denial of NODATA", qname: "www.example.", qtype: dns.TypeAAAA, want: DNSSECIndeterminate,
+			override: map[string]dnssecTestReply{"www.example./AAAA": {ns: exampleSOA}},
+		},
+		{name: "NSEC3 proves NXDOMAIN", qname: "missing.nsec3.", qtype: dns.TypeA, want: DNSSECSecure},
+		{
+			name: "NSEC3 NXDOMAIN without next closer denial", qname: "missing.nsec3.", qtype: dns.TypeA, want: DNSSECBogus,
+			override: map[string]dnssecTestReply{"missing.nsec3./A": {rcode: dns.RcodeNameError, ns: slices.Concat(nsec3SOA, nsec3Apex)}},
+		},
+		{
+			name: "NSEC3 NXDOMAIN for an existing name", qname: "host.nsec3.", qtype: dns.TypeA, want: DNSSECBogus,
+			override: map[string]dnssecTestReply{"host.nsec3./A": {rcode: dns.RcodeNameError, ns: slices.Concat(nsec3SOA, nsec3Apex, nsec3Host)}},
+		},
+		{name: "NSEC3 proves NODATA", qname: "host.nsec3.", qtype: dns.TypeAAAA, want: DNSSECSecure},
+		{
+			name: "NSEC3 NODATA for a listed type", qname: "host.nsec3.", qtype: dns.TypeA, want: DNSSECBogus,
+			override: map[string]dnssecTestReply{"host.nsec3./A": {ns: slices.Concat(nsec3SOA, nsec3Host)}},
+		},
+		{name: "NSEC3 opt-out delegation", qname: "www.deleg.nsec3.", qtype: dns.TypeA, want: DNSSECInsecure},
+		{
+			name: "NSEC3 without closest encloser for DS", qname: "www.deleg.nsec3.", qtype: dns.TypeA, want: DNSSECBogus,
+			override: map[string]dnssecTestReply{"deleg.nsec3./DS": {ns: slices.Concat(nsec3SOA, nsec3Host)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies := dnssecTestReplies()
+			for key, reply := range tt.override {
+				replies[key] = reply
+			}
+			validator := &DNSSECValidator{
+				Resolver:     &DNSResolver{Server: startDNSSECTestServer(t, replies), Timeout: 2 * time.Second},
+				TrustAnchors: []*dns.DS{anchor.(*dns.DS)},
+			}
+			result := validator.Validate(context.Background(), tt.qname, tt.qtype)
+			if result.Status != tt.want {
+				t.Errorf("status %s (%s), want %s", result.Status, result.Reason, tt.want)
+			}
+		})
+	}
+}
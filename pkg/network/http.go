@@ -59,6 +59,7 @@ func (h *Http) client() *http.Client {
         KeepAlive:    60 * time.Second,
         fallbackDelay: 300 * time.Millisecond,
         resolver:     net.DefaultResolver,
+        DNS:          ConfiguredDNSResolver(),
     }
 
 	transport := &http.Transport{
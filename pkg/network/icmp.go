@@ -0,0 +1,46 @@
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"golang.org/x/net/icmp"
+)
+
+// ICMP socket modes the agent can run in
+const (
+	ICMPModePrivileged   = "privileged"
+	ICMPModeUnprivileged = "unprivileged"
+	ICMPModeUnavailable  = "unavailable"
+)
+
+var icmpMode = ICMPModePrivileged
+
+// DetectICMPMode checks whether raw ICMP sockets are allowed and falls back to datagram ICMP sockets
+func DetectICMPMode() string {
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err == nil {
+		conn.Close()
+		icmpMode = ICMPModePrivileged
+		return icmpMode
+	}
+	log.Debugf("Raw ICMP socket unavailable: %v", err)
+
+	// Datagram ICMP sockets need the process group in net.ipv4.ping_group_range on Linux
+	conn, err = icmp.ListenPacket("udp4", "0.0.0.0")
+	if err == nil {
+		conn.Close()
+		icmpMode = ICMPModeUnprivileged
+		return icmpMode
+	}
+	log.Debugf("Datagram ICMP socket unavailable: %v", err)
+
+	icmpMode = ICMPModeUnavailable
+	return icmpMode
+}
+
+// GetICMPMode returns the ICMP socket mode chosen by DetectICMPMode
+func GetICMPMode() string {
+	return icmpMode
+}
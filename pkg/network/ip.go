@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/netip"
 	"net/url"
 	"regexp"
 	"strings"
@@ -53,6 +54,36 @@ func FilterIP(input string, args ...string) (ip string, host string, port string
 	return ip, host, port, ipVersion, err
 }
 
+// FilterCIDR accepts a network in CIDR notation besides everything FilterIP does, a single host becomes a /32 or /128.
+// FilterIP itself keeps rejecting networks since its callers expect one address.
+func FilterCIDR(input string, args ...string) (prefix netip.Prefix, ipVersion string, err error) {
+	input = strings.Trim(input, " \n\"'")
+	if strings.Contains(input, "/") && !strings.Contains(input, "://") {
+		prefix, err = netip.ParsePrefix(strings.Trim(input, "[]"))
+		if err != nil {
+			return netip.Prefix{}, "", fmt.Errorf("invalid CIDR: %s", input)
+		}
+		prefix = prefix.Masked()
+	} else {
+		ip, _, _, _, err := FilterIP(strings.Trim(input, "[]"), args...)
+		if err != nil {
+			return netip.Prefix{}, "", err
+		}
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return netip.Prefix{}, "", fmt.Errorf("invalid IP address: %s", ip)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	if prefix.Addr().Is4() {
+		ipVersion = "IPv4"
+	} else {
+		ipVersion = "IPv6"
+	}
+	return prefix, ipVersion, nil
+}
+
 
 func GetIP(ipVersion string) (interface{}, error)  {
     var ip string
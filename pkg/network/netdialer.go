@@ -18,6 +18,8 @@ type NetDialer struct {
     fallbackDelay  time.Duration
     resolver       *net.Resolver
     preferIPv4     *bool
+    // DNS resolves hosts through a chosen server and transport, such as DoT or DoH, instead of resolver
+    DNS            *DNSResolver
 }
 
 func (d *NetDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -32,7 +34,12 @@ func (d *NetDialer) DialContext(ctx context.Context, network, addr string) (net.
         return nil, err
     }
 
-    ips, err := d.resolver.LookupIPAddr(ctx, host)
+    var ips []net.IPAddr
+    if d.DNS != nil {
+        ips, err = d.DNS.LookupIPAddr(ctx, host)
+    } else {
+        ips, err = d.resolver.LookupIPAddr(ctx, host)
+    }
     if err != nil {
         return nil, err
     }
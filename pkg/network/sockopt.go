@@ -0,0 +1,36 @@
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// isIPv6Network reports whether a dial network name refers to IPv6
+func isIPv6Network(network string) bool {
+	return strings.HasSuffix(network, "6")
+}
+
+// SetDontFragment forbids fragmentation of packets sent on conn so oversized probes fail visibly
+func SetDontFragment(conn interface{}, ipv6 bool) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("connection %T does not expose its socket", conn)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		sockErr = setDontFragment(fd, ipv6)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
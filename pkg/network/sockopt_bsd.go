@@ -0,0 +1,28 @@
+//go:build darwin || freebsd
+
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// IPV6_DONTFRAG is shared by the BSDs but missing from the syscall package
+const ipv6DontFrag = 62
+
+// setDontFragment sets the don't-fragment flag on outgoing packets
+func setDontFragment(fd uintptr, ipv6 bool) error {
+	// IP_DONTFRAG differs between FreeBSD and Darwin
+	ipDontFrag := 0x43
+	if runtime.GOOS == "darwin" {
+		ipDontFrag = 28
+	}
+	if ipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6DontFrag, 1)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, ipDontFrag, 1)
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setDontFragment is not implemented on this platform
+func setDontFragment(fd uintptr, ipv6 bool) error {
+	return fmt.Errorf("don't fragment is not supported on %s", runtime.GOOS)
+}
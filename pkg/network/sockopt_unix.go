@@ -0,0 +1,45 @@
+//go:build !windows
+
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"errors"
+	"syscall"
+)
+
+// TTLControl returns a dialer control function that sets the unicast TTL (IPv4) or hop limit (IPv6) before connecting
+func TTLControl(ttl int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			if isIPv6Network(network) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+			} else {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// IsConnRefused reports whether a dial error was caused by the peer resetting the connection attempt
+func IsConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// IsPortUnreachable reports whether a read on a connected UDP socket failed because of an ICMP port unreachable
+func IsPortUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// IsMsgTooLarge reports whether a send failed because the packet exceeds the local MTU
+func IsMsgTooLarge(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
@@ -0,0 +1,63 @@
+//go:build windows
+
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Winsock values that the syscall package does not define
+const (
+	wsaEMSGSIZE      = syscall.Errno(10040)
+	wsaECONNRESET    = syscall.Errno(10054)
+	wsaECONNREFUSED  = syscall.Errno(10061)
+	ipDontFragment   = 14
+	ipv6DontFragment = 14
+)
+
+// TTLControl returns a dialer control function that sets the unicast TTL (IPv4) or hop limit (IPv6) before connecting
+func TTLControl(ttl int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			if isIPv6Network(network) {
+				sockErr = syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+			} else {
+				sockErr = syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// IsConnRefused reports whether a dial error was caused by the peer resetting the connection attempt
+func IsConnRefused(err error) bool {
+	return errors.Is(err, wsaECONNREFUSED)
+}
+
+// IsPortUnreachable reports whether a read on a connected UDP socket failed because of an ICMP port unreachable
+func IsPortUnreachable(err error) bool {
+	// Winsock reports the ICMP error on UDP sockets as a connection reset
+	return errors.Is(err, wsaECONNRESET) || errors.Is(err, wsaECONNREFUSED)
+}
+
+// IsMsgTooLarge reports whether a send failed because the packet exceeds the local MTU
+func IsMsgTooLarge(err error) bool {
+	return errors.Is(err, wsaEMSGSIZE)
+}
+
+// setDontFragment sets the don't-fragment flag on outgoing packets
+func setDontFragment(fd uintptr, ipv6 bool) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, ipv6DontFragment, 1)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, ipDontFragment, 1)
+}
@@ -0,0 +1,20 @@
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import "time"
+
+// TCPInfo holds the kernel state of a TCP connection that the tasks report
+type TCPInfo struct {
+	PMTU         int
+	SndMSS       int
+	RcvMSS       int
+	AdvMSS       int
+	Timestamps   bool
+	RTT          time.Duration
+	RTTVar       time.Duration
+	Retransmits  int
+	TotalRetrans int
+}
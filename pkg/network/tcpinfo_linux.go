@@ -0,0 +1,49 @@
+//go:build linux
+
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpiOptTimestamps is the TCP_INFO option bit for negotiated timestamps
+const tcpiOptTimestamps = 1
+
+// GetTCPInfo reads TCP_INFO from an established connection
+func GetTCPInfo(conn *net.TCPConn) (*TCPInfo, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var info *unix.TCPInfo
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+
+	return &TCPInfo{
+		PMTU:         int(info.Pmtu),
+		SndMSS:       int(info.Snd_mss),
+		RcvMSS:       int(info.Rcv_mss),
+		AdvMSS:       int(info.Advmss),
+		Timestamps:   info.Options&tcpiOptTimestamps != 0,
+		RTT:          time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:       time.Duration(info.Rttvar) * time.Microsecond,
+		Retransmits:  int(info.Retransmits),
+		TotalRetrans: int(info.Total_retrans),
+	}, nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+/*
+Copyright © 2024-2025 Admin.IM <dev@admin.im>
+*/
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// GetTCPInfo reads TCP_INFO from an established connection
+func GetTCPInfo(conn *net.TCPConn) (*TCPInfo, error) {
+	return nil, fmt.Errorf("tcp info is not supported on %s", runtime.GOOS)
+}
@@ -5,6 +5,7 @@ Copyright © 2024-2025 Admin.IM <dev@admin.im>
 package network
 
 import (
+	"context"
 	"crypto/tls"
 	_ "embed"
 	"net"
@@ -21,9 +22,19 @@ type Websocket struct {
 	Timeout     time.Duration
 	Jar         *cookiejar.Jar
 	Certificate *Certificate
+	// Header is sent with the upgrade request, a User-Agent in it replaces the agent default
+	Header       http.Header
+	Subprotocols []string
+	// InsecureSkipVerify disables server certificate verification
+	InsecureSkipVerify bool
 }
 
 func (w *Websocket) Dial() (*websocket.Conn, *http.Response, error)  {
+	return w.DialContext(context.Background())
+}
+
+// DialContext dials like Dial, the context carries deadlines and an optional httptrace.ClientTrace
+func (w *Websocket) DialContext(ctx context.Context) (*websocket.Conn, *http.Response, error) {
 	if w.Timeout == 0 {
 		w.Timeout = 30
 	}
@@ -33,13 +44,18 @@ func (w *Websocket) Dial() (*websocket.Conn, *http.Response, error)  {
         KeepAlive:    60 * time.Second,
         fallbackDelay: 300 * time.Millisecond,
         resolver:     net.DefaultResolver,
+        DNS:          ConfiguredDNSResolver(),
     }
 
 	wd := &websocket.Dialer{
         HandshakeTimeout: w.Timeout * time.Second,
         NetDialContext:   netdialer.DialContext,
-		Jar: w.Jar,
+		Subprotocols: w.Subprotocols,
     }
+	// A nil *cookiejar.Jar must not end up as a non-nil http.CookieJar
+	if w.Jar != nil {
+		wd.Jar = w.Jar
+	}
 
 	if w.Certificate != nil {
 		cert, err := tls.X509KeyPair(w.Certificate.CertPem, w.Certificate.CertKey)
@@ -53,9 +69,20 @@ func (w *Websocket) Dial() (*websocket.Conn, *http.Response, error)  {
 		wd.TLSClientConfig = tlsConfig
 
 	}
+	if w.InsecureSkipVerify {
+		if wd.TLSClientConfig == nil {
+			wd.TLSClientConfig = &tls.Config{}
+		}
+		wd.TLSClientConfig.InsecureSkipVerify = true
+	}
 
 	header := http.Header{}
-    header.Set("User-Agent", "Adm-agent/" + viper.GetString("version"))
+	for key, values := range w.Header {
+		header[key] = values
+	}
+	if header.Get("User-Agent") == "" {
+		header.Set("User-Agent", "Adm-agent/" + viper.GetString("version"))
+	}
 
-	return wd.Dial(w.Url, header)
+	return wd.DialContext(ctx, w.Url, header)
 }
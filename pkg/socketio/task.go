@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/admuu/adm-agent/pkg/components"
+	"github.com/admuu/adm-agent/pkg/network"
 	"github.com/admuu/adm-agent/pkg/utils"
 	"github.com/gorilla/websocket"
 )
@@ -18,6 +19,22 @@ func (s *SocketIO) initTaskHandlers() {
 	s.taskRegistry = components.NewTaskRegistry()
 	s.taskRegistry.RegisterHandler(&components.PingHandler{})
 	s.taskRegistry.RegisterHandler(&components.WebspeedHandler{})
+	s.taskRegistry.RegisterHandler(&components.TracerouteHandler{})
+	s.taskRegistry.RegisterHandler(&components.MtrHandler{})
+	s.taskRegistry.RegisterHandler(&components.DNSHandler{})
+	s.taskRegistry.RegisterHandler(&components.TLSHandler{})
+	s.taskRegistry.RegisterHandler(&components.PageloadHandler{})
+	s.taskRegistry.RegisterHandler(&components.UDPHandler{})
+	s.taskRegistry.RegisterHandler(&components.NTPHandler{})
+	s.taskRegistry.RegisterHandler(&components.PMTUHandler{})
+	s.taskRegistry.RegisterHandler(&components.BannerHandler{})
+	s.taskRegistry.RegisterHandler(&components.WSProbeHandler{})
+	s.taskRegistry.RegisterHandler(&components.GRPCHandler{})
+	s.taskRegistry.RegisterHandler(&components.ThroughputHandler{})
+	s.taskRegistry.RegisterHandler(&components.TWAMPHandler{})
+	s.taskRegistry.RegisterHandler(&components.DNSPropHandler{})
+	s.taskRegistry.RegisterHandler(&components.PingBatchHandler{})
+	s.taskRegistry.RegisterHandler(&components.SweepHandler{})
 }
 
 // SendMessage sends a message with given event and data
@@ -231,11 +248,12 @@ func (s *SocketIO) delay() {
 	s.dialerTimes++
 }
 
-// sayHello sends initial authentication message with token
+// sayHello sends initial authentication message with token and ICMP mode
 func (s *SocketIO) sayHello() {
 	eventName := "agent-task"
 	eventData := map[string]interface{}{
-		"token": s.token,
+		"token":    s.token,
+		"icmpMode": network.GetICMPMode(),
 	}
 	message, _ := s.escapedString(eventName, eventData)
 	s.messageChan <- WebSocketMessage{websocket.TextMessage, []byte(message)}